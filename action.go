@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+)
+
+const clickActionLabel = "Open"
+
+// actionSchemes are the url schemes of links added to emails, other schemes
+// like javascript: are never linked
+var actionSchemes = []string{"http", "https", "mailto"}
+
+// Action represents a link added to emails as a call to action
+type Action struct {
+	AppID *uint  // Optional: only add the link to messages of this application
+	Label string // Text of the link
+	URL   string // Absolute url or relative to the base url
+}
+
+// ============================================================================
+
+// isValid is used to validate the action configuration
+func (a *Action) isValid() error {
+	v := &validation{}
+	if a.Label == "" {
+		v.add("label", errors.New("the label is required"))
+	}
+	u, err := url.Parse(a.URL)
+	switch {
+	case a.URL == "":
+		v.add("url", errors.New("the url is required"))
+	case err != nil:
+		v.addf("url", "the url %q is not valid: %w", a.URL, err)
+	case u.IsAbs():
+		v.url("url", a.URL, actionSchemes...)
+	}
+
+	return v.err()
+}
+
+// ============================================================================
+
+// actions is used to get the call to action links of a message, starting with
+// the notification click url
+func (c *Config) actions(msg Message) []Action {
+	var actions []Action
+
+	click := msg.extraString("client::notification", "click", "url")
+	if click != "" {
+		actions = append(actions, Action{Label: clickActionLabel, URL: click})
+	}
+	for _, a := range c.Actions {
+		if a.AppID != nil && *a.AppID != msg.AppID {
+			continue
+		}
+		actions = append(actions, a)
+	}
+
+	resolved := make([]Action, 0, len(actions))
+	for _, a := range actions {
+		uri, err := c.resolveActionURL(a.URL)
+		if err != nil {
+			log.Printf("SMTP Emailer: could not add action %q: %v\n", a.Label, err)
+			continue
+		}
+		a.URL = uri
+		resolved = append(resolved, a)
+	}
+
+	return resolved
+}
+
+// resolveActionURL is used to resolve the url of an action, only http, https
+// and mailto urls are linked
+func (c *Config) resolveActionURL(ref string) (string, error) {
+	uri, err := c.resolveURL(ref)
+	if err != nil {
+		return "", err
+	}
+	v := &validation{}
+	v.url("", uri, actionSchemes...)
+
+	return uri, v.err()
+}
+
+// resolveURL is used to resolve a url relative to the base url
+func (c *Config) resolveURL(ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("could not parse url: %w", err)
+	}
+	if u.IsAbs() {
+		return u.String(), nil
+	}
+	if c.BaseURL == nil {
+		return "", fmt.Errorf("relative url %q needs a base url", ref)
+	}
+
+	base, err := url.Parse(*c.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse base url: %w", err)
+	}
+
+	return base.ResolveReference(u).String(), nil
+}
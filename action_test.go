@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestActions(t *testing.T) {
+	click := func(uri string) map[string]interface{} {
+		return map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]interface{}{"url": uri},
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		baseURL *string
+		actions []Action
+		msg     Message
+		want    []Action
+	}{
+		{
+			name: "should add click url",
+			msg:  Message{Extras: click("https://dashboard.example.com/d/1")},
+			want: []Action{{Label: clickActionLabel, URL: "https://dashboard.example.com/d/1"}},
+		},
+		{
+			name:    "should resolve relative click url",
+			baseURL: toPtr("https://gotify.example.com/"),
+			msg:     Message{Extras: click("/#/messages")},
+			want:    []Action{{Label: clickActionLabel, URL: "https://gotify.example.com/#/messages"}},
+		},
+		{
+			name: "should skip relative click url without base url",
+			msg:  Message{Extras: click("/#/messages")},
+			want: []Action{},
+		},
+		{
+			name: "should skip click url of other schemes",
+			msg:  Message{Extras: click("javascript:alert(1)")},
+			want: []Action{},
+		},
+		{
+			name: "should add mailto click url",
+			msg:  Message{Extras: click("mailto:ops@example.com")},
+			want: []Action{{Label: clickActionLabel, URL: "mailto:ops@example.com"}},
+		},
+		{
+			name: "should add actions of the application",
+			actions: []Action{
+				{AppID: toPtr(uint(1)), Label: "Runbook", URL: "https://wiki.example.com/runbook"},
+				{AppID: toPtr(uint(2)), Label: "Other", URL: "https://wiki.example.com/other"},
+				{Label: "Status", URL: "https://status.example.com"},
+			},
+			msg: Message{AppID: 1},
+			want: []Action{
+				{AppID: toPtr(uint(1)), Label: "Runbook", URL: "https://wiki.example.com/runbook"},
+				{Label: "Status", URL: "https://status.example.com"},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			cfg := baseConfig
+			cfg.BaseURL = tt.baseURL
+			cfg.Actions = tt.actions
+			require.NoError(t, cfg.IsValid())
+
+			got := cfg.actions(tt.msg)
+			require.Equal(t, tt.want, got)
+
+			email := cfg.render(tt.msg, nil)
+			for _, a := range tt.want {
+				require.Contains(t, email.HTML, "href=\""+a.URL+"\"")
+				require.Contains(t, email.Text, a.Label+": "+a.URL)
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestActionIsValid(t *testing.T) {
+	require.NoError(t, (&Action{Label: "Runbook", URL: "https://wiki.example.com/runbook"}).isValid())
+	require.NoError(t, (&Action{Label: "Mail", URL: "mailto:ops@example.com"}).isValid())
+	require.NoError(t, (&Action{Label: "Messages", URL: "/#/messages"}).isValid())
+	require.Error(t, (&Action{Label: "Script", URL: "javascript:alert(1)"}).isValid())
+	require.Error(t, (&Action{Label: "File", URL: "file:///etc/passwd"}).isValid())
+}
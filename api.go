@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxAPIResponseBytes is the largest Gotify api response read
+const maxAPIResponseBytes = 10 << 20
+
+// apiGet is used to get a resource of the Gotify REST API with the client
+// token and to unmarshal it into v
+func (c *Plugin) apiGet(path string, v interface{}) error {
+	b, err := c.apiRequest(path)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal api response: %w", err)
+	}
+
+	return nil
+}
+
+// apiRequest is used to get the raw body of a Gotify resource with the client
+// token
+func (c *Plugin) apiRequest(path string) ([]byte, error) {
+	token, err := c.clientToken(false)
+	if err != nil {
+		return nil, fmt.Errorf("could not get client token: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.config.httpURL()+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not make api request: %w", err)
+	}
+	req.Header.Add("X-Gotify-Key", token)
+	req.Header.Add("Accept", "application/json")
+
+	httpClient := http.Client{Timeout: 10 * time.Second}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not do api request: %w", err)
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxAPIResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not read api response: %w", err)
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		return nil, errUnauthorized
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invalid api response: %d: %s", res.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	return b, nil
+}
+
+// message is used to get a message from Gotify, false is returned when the
+// message does not exist
+func (c *Plugin) message(id uint) (Message, bool, error) {
+	// messages are listed newest first starting below since
+	var page struct {
+		Messages []Message
+	}
+	err := c.apiGet(fmt.Sprintf("/message?limit=1&since=%d", id+1), &page)
+	if err != nil {
+		return Message{}, false, err
+	}
+	if len(page.Messages) == 0 || page.Messages[0].ID != id {
+		return Message{}, false, nil
+	}
+
+	return page.Messages[0], true, nil
+}
+
+// messageExists is used to check if a message is still in Gotify
+func (c *Plugin) messageExists(id uint) (bool, error) {
+	_, ok, err := c.message(id)
+	return ok, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// appsRefreshInterval is the least time between refreshing the applications
+// for an unknown application
+const appsRefreshInterval = time.Minute
+
+// application represents a Gotify application
+type application struct {
+	ID          uint
+	Name        string
+	Description string
+	Image       string // Path of the application icon relative to the Gotify url
+}
+
+// ============================================================================
+
+// application is used to get a Gotify application by id, applications are
+// cached and refreshed when an unknown application is asked for
+func (c *Plugin) application(id uint) (application, bool) {
+	c.appsMu.Lock()
+	defer c.appsMu.Unlock()
+
+	app, ok := c.apps[id]
+	if ok || time.Since(c.appsFetched) < appsRefreshInterval {
+		return app, ok
+	}
+
+	var apps []application
+	err := c.apiGet("/application", &apps)
+	c.appsFetched = time.Now()
+	if err != nil {
+		log.Printf("SMTP Emailer: could not get applications: %v\n", err)
+		return app, false
+	}
+
+	c.apps = make(map[uint]application, len(apps))
+	c.icons = nil
+	for _, a := range apps {
+		c.apps[a.ID] = a
+	}
+	app, ok = c.apps[id]
+
+	return app, ok
+}
+
+// appName is used to get the name of a Gotify application, the name is empty
+// when the application is unknown
+func (c *Plugin) appName(id uint) string {
+	app, ok := c.application(id)
+	if !ok {
+		return ""
+	}
+
+	return app.Name
+}
+
+// appIcon is used to get the icon of a Gotify application to embed in emails,
+// icons are cached by their path and failures until the applications are
+// refreshed
+func (c *Plugin) appIcon(app application) *Attachment {
+	if app.Image == "" || c.config.Images.Disabled {
+		return nil
+	}
+
+	c.appsMu.Lock()
+	icon, ok := c.icons[app.Image]
+	c.appsMu.Unlock()
+	if ok {
+		return icon
+	}
+
+	icon, err := c.fetchIcon(app.Image)
+	if err != nil {
+		log.Printf("SMTP Emailer: could not get icon of application %d: %v\n", app.ID, err)
+	}
+
+	c.appsMu.Lock()
+	defer c.appsMu.Unlock()
+	if c.icons == nil {
+		c.icons = map[string]*Attachment{}
+	}
+	c.icons[app.Image] = icon
+
+	return icon
+}
+
+// fetchIcon is used to download an application icon from Gotify
+func (c *Plugin) fetchIcon(image string) (*Attachment, error) {
+	data, err := c.apiRequest("/" + strings.TrimPrefix(image, "/"))
+	if err != nil {
+		return nil, err
+	}
+	maxBytes := c.config.Images.maxBytes()
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("icon is larger than %d bytes", maxBytes)
+	}
+
+	return newImage(data, image)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultClientName = "Gotify SMTP Emailer"
+
+// ManagedClient represents a Gotify client created and managed by the plugin
+type ManagedClient struct {
+	Name     *string // Optional: name of the created client
+	Username string  // Optional: Gotify user used to create the client, only needed while no client is stored
+	Password string  // Optional: password of the Gotify user, may be a file: or env: reference
+}
+
+// clientState represents a client created by the plugin
+type clientState struct {
+	ID    uint
+	Token string
+}
+
+var errUnauthorized = errors.New("token was rejected")
+
+// ============================================================================
+
+// isValid is used to validate the managed client configuration
+func (m *ManagedClient) isValid() error {
+	v := &validation{}
+	if (m.Username == "") != (m.Password == "") {
+		v.add("", errors.New("the username and password must be set together"))
+	}
+	if m.Password != "" {
+		_, err := resolveSecret(m.Password)
+		if err != nil {
+			v.addf("password", "the password could not be resolved: %w", err)
+		}
+	}
+
+	return v.err()
+}
+
+// ============================================================================
+
+// create is used to create a new Gotify client with the configured credentials
+func (m *ManagedClient) create(baseURL string) (clientState, error) {
+	var client clientState
+	if m.Username == "" {
+		return client, errors.New("no credentials set to create a client")
+	}
+	password, err := resolveSecret(m.Password)
+	if err != nil {
+		return client, fmt.Errorf("could not resolve client password: %w", err)
+	}
+
+	name := defaultClientName
+	if m.Name != nil && *m.Name != "" {
+		name = *m.Name
+	}
+	body, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return client, fmt.Errorf("could not marshal client request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/client", bytes.NewReader(body))
+	if err != nil {
+		return client, fmt.Errorf("could not make client request: %w", err)
+	}
+	req.SetBasicAuth(m.Username, password)
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+
+	httpClient := http.Client{Timeout: 10 * time.Second}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return client, fmt.Errorf("could not do client request: %w", err)
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return client, fmt.Errorf("could not read client response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return client, fmt.Errorf("invalid client response: %d: %s", res.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	err = json.Unmarshal(b, &client)
+	if err != nil {
+		return client, fmt.Errorf("could not unmarshal client response: %w", err)
+	}
+	if client.Token == "" {
+		return client, errors.New("no token in client response")
+	}
+
+	return client, nil
+}
+
+// ============================================================================
+
+// clientToken is used to get the token used to connect to Gotify. When the
+// client is managed by the plugin the stored token is used, a new client is
+// created when there is none or recreate is set.
+func (c *Plugin) clientToken(recreate bool) (string, error) {
+	if c.config.Client == nil {
+		return resolveSecret(c.config.Token)
+	}
+
+	data, err := c.loadStorage()
+	if err != nil {
+		return "", err
+	}
+	if !recreate && data.Client != nil && data.Client.Token != "" {
+		return data.Client.Token, nil
+	}
+
+	client, err := c.config.Client.create(c.config.httpURL())
+	if err != nil {
+		return "", fmt.Errorf("could not create client: %w", err)
+	}
+	log.Printf("SMTP Emailer: created client %d\n", client.ID)
+
+	err = c.updateStorage(func(data *storageData) {
+		data.Client = &client
+	})
+	if err != nil {
+		log.Printf("SMTP Emailer: could not store client: %v\n", err)
+	}
+
+	return client.Token, nil
+}
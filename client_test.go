@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStorage struct {
+	data []byte
+}
+
+func (m *memoryStorage) Save(b []byte) error {
+	m.data = b
+	return nil
+}
+
+func (m *memoryStorage) Load() ([]byte, error) {
+	return m.data, nil
+}
+
+func TestClientToken(t *testing.T) {
+	created := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "admin" || password != "admin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.Equal(t, "/client", r.URL.Path)
+
+		var body struct{ Name string }
+		err := json.NewDecoder(r.Body).Decode(&body)
+		require.NoError(t, err)
+		require.Equal(t, defaultClientName, body.Name)
+
+		created++
+		fmt.Fprintf(w, `{"id":%d,"name":%q,"token":"token-%d"}`, created, body.Name, created)
+	}))
+	defer srv.Close()
+
+	cfg := baseConfig
+	cfg.Hostname = strings.Replace(srv.URL, "http://", "ws://", 1)
+	cfg.Token = ""
+	cfg.Client = &ManagedClient{
+		Username: "admin",
+		Password: "admin",
+	}
+	require.NoError(t, cfg.IsValid())
+
+	p := &Plugin{config: &cfg}
+	p.SetStorageHandler(&memoryStorage{})
+
+	token, err := p.clientToken(false)
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+	t.Logf("\tP\tshould create client")
+
+	token, err = p.clientToken(false)
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+	t.Logf("\tP\tshould use stored client")
+
+	token, err = p.clientToken(true)
+	require.NoError(t, err)
+	require.Equal(t, "token-2", token)
+	t.Logf("\tP\tshould recreate client")
+
+	cfg.Client.Password = "wrong"
+	_, err = p.clientToken(true)
+	require.Error(t, err)
+	t.Logf("\tP\tshould not create client with invalid credentials")
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+const (
+	maxLineLength        = 78 // line length header fields are folded at (RFC 5322)
+	maxEncodedWordLength = 60 // length of encoded words in header fields, at most 75 (RFC 2047)
+)
+
+// headerField represents a header field of an email or of a MIME entity
+type headerField struct {
+	Name  string
+	Value string
+}
+
+// mimePart represents a MIME entity (RFC 2045), its header fields and its
+// encoded body
+type mimePart struct {
+	header []headerField
+	body   []byte
+}
+
+// ============================================================================
+
+// bytes is used to write the MIME entity with CRLF line endings
+func (p mimePart) bytes() []byte {
+	var b bytes.Buffer
+	b.Write(composeHeader(p.header))
+	b.WriteString("\r\n")
+	b.Write(p.body)
+
+	return b.Bytes()
+}
+
+// textPart is used to build a quoted-printable encoded text entity, line
+// breaks are converted to CRLF
+func textPart(subtype string, text string) (mimePart, error) {
+	var body bytes.Buffer
+	w := quotedprintable.NewWriter(&body)
+	_, err := w.Write([]byte(text))
+	if err != nil {
+		return mimePart{}, err
+	}
+	err = w.Close()
+	if err != nil {
+		return mimePart{}, err
+	}
+
+	return mimePart{
+		header: []headerField{
+			{"Content-Type", mime.FormatMediaType("text/"+subtype, map[string]string{"charset": "utf-8"})},
+			{"Content-Transfer-Encoding", "quoted-printable"},
+		},
+		body: body.Bytes(),
+	}, nil
+}
+
+// inlinePart is used to build a base64 encoded entity of an inline attachment
+func inlinePart(a Attachment) mimePart {
+	return mimePart{
+		header: []headerField{
+			{"Content-Type", a.ContentType},
+			{"Content-Transfer-Encoding", "base64"},
+			{"Content-ID", "<" + a.ContentID + ">"},
+			{"Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": a.Filename})},
+		},
+		body: []byte(base64Lines(a.Data) + "\r\n"),
+	}
+}
+
+// multipartPart is used to build a multipart entity of the parts, params are
+// added to the content type next to the boundary
+func multipartPart(subtype string, params map[string]string, parts ...mimePart) (mimePart, error) {
+	id, err := randomID()
+	if err != nil {
+		return mimePart{}, err
+	}
+	// "=_" can not occur in quoted-printable or base64 encoded parts
+	boundary := "=_" + id
+
+	var body bytes.Buffer
+	for _, part := range parts {
+		body.WriteString("--" + boundary + "\r\n")
+		body.Write(part.bytes())
+		body.WriteString("\r\n")
+	}
+	body.WriteString("--" + boundary + "--\r\n")
+
+	mediaParams := map[string]string{"boundary": boundary}
+	for k, v := range params {
+		mediaParams[k] = v
+	}
+
+	return mimePart{
+		header: []headerField{{"Content-Type", mime.FormatMediaType("multipart/"+subtype, mediaParams)}},
+		body:   body.Bytes(),
+	}, nil
+}
+
+// composeBody is used to build the body entity of an email. The plain text is
+// sent as an alternative to the html, inline images are sent next to the html
+// as multipart/related.
+func composeBody(email *Email) (mimePart, error) {
+	body, err := textPart("html", email.HTML)
+	if err != nil {
+		return mimePart{}, err
+	}
+
+	if len(email.Inline) > 0 {
+		parts := []mimePart{body}
+		for _, a := range email.Inline {
+			parts = append(parts, inlinePart(a))
+		}
+		body, err = multipartPart("related", nil, parts...)
+		if err != nil {
+			return mimePart{}, err
+		}
+	}
+
+	if email.Text != "" {
+		text, err := textPart("plain", email.Text)
+		if err != nil {
+			return mimePart{}, err
+		}
+		body, err = multipartPart("alternative", nil, text, body)
+		if err != nil {
+			return mimePart{}, err
+		}
+	}
+
+	return body, nil
+}
+
+// ============================================================================
+
+// composeHeader is used to write header fields folded to lines of at most 78
+// characters where possible
+func composeHeader(fields []headerField) []byte {
+	var b bytes.Buffer
+	for _, f := range fields {
+		b.WriteString(foldHeader(f.Name + ": " + f.Value))
+		b.WriteString("\r\n")
+	}
+
+	return b.Bytes()
+}
+
+// foldHeader is used to fold a header field at whitespace, the field name is
+// kept on a line with the first word of the value
+func foldHeader(field string) string {
+	var folded strings.Builder
+	line := 0
+	for i, word := range strings.Split(field, " ") {
+		if i > 0 {
+			if i > 1 && line+1+len(word) > maxLineLength {
+				folded.WriteString("\r\n")
+				line = 0
+			}
+			folded.WriteString(" ")
+			line++
+		}
+		folded.WriteString(word)
+		line += len(word)
+	}
+
+	return folded.String()
+}
+
+// encodeHeader is used to encode an unstructured header value (RFC 2047),
+// line breaks are removed so that the value can not add header fields
+func encodeHeader(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if mime.QEncoding.Encode("utf-8", value) == value {
+		return value
+	}
+
+	// the value is split into short encoded words so that it can be folded,
+	// decoders ignore the whitespace between encoded words
+	var words []string
+	var chunk string
+	for _, r := range value {
+		if chunk != "" && len(encodeWord(chunk+string(r))) > maxEncodedWordLength {
+			words = append(words, encodeWord(chunk))
+			chunk = ""
+		}
+		chunk += string(r)
+	}
+	words = append(words, encodeWord(chunk))
+
+	return strings.Join(words, " ")
+}
+
+// encodeWord is used to encode text as a "Q" encoded word
+func encodeWord(text string) string {
+	var word strings.Builder
+	word.WriteString("=?utf-8?q?")
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+		switch {
+		case b == ' ':
+			word.WriteByte('_')
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9',
+			b == '!', b == '*', b == '+', b == '-', b == '/':
+			word.WriteByte(b)
+		default:
+			fmt.Fprintf(&word, "=%02X", b)
+		}
+	}
+	word.WriteString("?=")
+
+	return word.String()
+}
+
+// formatAddress is used to format an address with an optional display name
+func formatAddress(name *string, email string) string {
+	address := &mail.Address{Address: email}
+	if name != nil {
+		address.Name = *name
+	}
+
+	return addressString(address)
+}
+
+// addressString is used to format an address, the angle brackets are left out
+// without a display name
+func addressString(address *mail.Address) string {
+	if address.Name == "" {
+		return strings.TrimSuffix(strings.TrimPrefix(address.String(), "<"), ">")
+	}
+
+	return address.String()
+}
+
+// formatAddressList is used to format a list of addresses, an address may
+// include a display name
+func formatAddressList(emails []string) (string, error) {
+	formatted := make([]string, 0, len(emails))
+	for _, email := range emails {
+		address, err := mail.ParseAddress(email)
+		if err != nil {
+			return "", fmt.Errorf("could not parse address %q: %w", email, err)
+		}
+		formatted = append(formatted, addressString(address))
+	}
+
+	return strings.Join(formatted, ", "), nil
+}
+
+// envelopeAddress is used to get the bare address of an email for the SMTP
+// envelope and key lookups, the display name is left out
+func envelopeAddress(email string) string {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return email
+	}
+
+	return address.Address
+}
+
+// formatDate is used to format the Date header of an email
+func formatDate(t time.Time) string {
+	return t.Format(time.RFC1123Z)
+}
+
+// newMessageID is used to generate a unique Message-ID in the domain of the
+// sender
+func newMessageID(from string) (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	domain := "gotify-smtp-emailer"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+
+	return fmt.Sprintf("<%s@%s>", id, domain), nil
+}
+
+// base64Lines is used to base64 encode data into lines of at most 76
+// characters
+func base64Lines(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var lines strings.Builder
+	for len(encoded) > 76 {
+		lines.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	lines.WriteString(encoded)
+
+	return lines.String()
+}
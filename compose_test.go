@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeMessage(t *testing.T) {
+	s := baseConfig.Smtp
+	s.From.Name = toPtr("Gotify Bénédicte")
+	s.ToEmails = []string{"Zoë <to@email.com>", "other@email.com"}
+
+	text := "Température élevée\n" + strings.Repeat("a very long line ", 20)
+	messages, err := s.build(&Email{
+		Subject: "Alerte: température\r\nBcc: injected@email.com",
+		HTML:    "<p>" + text + "</p>",
+		Text:    text,
+	})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	content := messages[0].content
+
+	require.NotRegexp(t, regexp.MustCompile(`[^\r]\n`), string(content))
+	for _, line := range strings.Split(string(content), "\r\n") {
+		require.LessOrEqual(t, len(line), 78, line)
+	}
+	t.Logf("\tP\tshould use CRLF line endings and short lines")
+
+	msg, err := mail.ReadMessage(bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, "1.0", msg.Header.Get("MIME-Version"))
+	_, err = msg.Header.Date()
+	require.NoError(t, err)
+	require.Regexp(t, `^<[0-9a-f]{32}@email\.com>$`, msg.Header.Get("Message-ID"))
+	require.Empty(t, msg.Header.Get("Bcc"))
+	t.Logf("\tP\tshould add Date, MIME-Version and Message-ID headers")
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	require.Equal(t, "Test Subject: Alerte: température Bcc: injected@email.com", subject)
+	from, err := msg.Header.AddressList("From")
+	require.NoError(t, err)
+	require.Equal(t, "Gotify Bénédicte", from[0].Name)
+	to, err := msg.Header.AddressList("To")
+	require.NoError(t, err)
+	require.Equal(t, []*mail.Address{{Name: "Zoë", Address: "to@email.com"}, {Address: "other@email.com"}}, to)
+	t.Logf("\tP\tshould encode subject and display names")
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", mediaType)
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	part, err := r.NextPart()
+	require.NoError(t, err)
+	require.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+	// the multipart reader decodes quoted-printable parts itself
+	decoded, err := io.ReadAll(part)
+	require.NoError(t, err)
+	require.Equal(t, strings.ReplaceAll(text, "\n", "\r\n"), string(decoded))
+	t.Logf("\tP\tshould encode text parts as quoted-printable")
+
+	_, err = s.build(&Email{Subject: "test title", HTML: "<p>test message</p>"})
+	require.NoError(t, err)
+	s.ToEmails = []string{"not an email"}
+	_, err = s.build(&Email{Subject: "test title", HTML: "<p>test message</p>"})
+	require.Error(t, err)
+	t.Logf("\tP\tshould not accept invalid addresses")
+}
+
+func TestFoldHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		lines int
+	}{
+		{name: "should not fold short fields", field: "Subject: short", lines: 1},
+		{name: "should fold long fields at whitespace", field: "Subject: " + strings.Repeat("word ", 40), lines: 3},
+		{name: "should not split long words", field: "Subject: " + strings.Repeat("a", 100), lines: 1},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			folded := foldHeader(tt.field)
+			lines := strings.Split(folded, "\r\n")
+			require.Len(t, lines, tt.lines)
+			for _, line := range lines[1:] {
+				require.True(t, strings.HasPrefix(line, " "))
+			}
+			require.Equal(t, tt.field, strings.ReplaceAll(folded, "\r\n", ""))
+		}
+
+		t.Run(tt.name, test)
+	}
+}
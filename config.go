@@ -7,20 +7,45 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 )
 
 // Config represents the config used for the plugin
 type Config struct {
-	Hostname string // This will be local because they are running on same machine
-	Token    string //Token from client needed for ws connection
-	Smtp     Smtp
-	// production or development, used for logging and sending messages on a loop
+	// Optional: schema version of the config, older configs are migrated when
+	// loaded, configs without a version are version 0
+	Version    int
+	Hostname   string         // This will be local because they are running on same machine
+	Token      string         //Token from client needed for ws connection, may be a file: or env: reference
+	Client     *ManagedClient // Optional: used instead of token to let the plugin manage its own client
+	Smtp       Smtp
+	Relays     []Smtp              // Optional: fallback smtp relays tried in order when smtp fails
+	Failover   Failover            // Optional: how failing relays are skipped
+	Output     Output              // Optional: where emails are delivered, defaults to smtp
+	Images     Images              // Optional: how the notification big image is included
+	BaseURL    *string             // Optional: public Gotify url used to resolve relative links
+	Actions    []Action            // Optional: links added to emails next to the notification click url
+	Threading  *Threading          // Optional: thread emails of an application in mail clients
+	QuietHours *QuietHours         // Optional: hold low priority emails during quiet hours
+	Escalation *Escalation         // Optional: email more recipients while high priority messages are not deleted
+	Filters    []Filter            // Optional: rules deciding which messages are emailed
+	Timezone   *string             // Optional: IANA timezone of routing times, email dates and quiet hours like Europe/Berlin, defaults to the server timezone
+	Templates  map[string]Template // Optional: named email templates used by routes
+	Transports map[string]Output   // Optional: named outputs used by routes
+	Routes     []Route             // Optional: routing rules, messages matching none are emailed as usual
+	DryRun     bool                // Optional: record emails instead of sending them
+	// Optional: check the smtp servers and Gotify accept the config when it is
+	// saved, without sending an email
+	VerifyOnSave bool
+	Diagnostics  Diagnostics // Optional: logging, notices, heartbeat and error reporting of the plugin
+	// Deprecated: replaced by Diagnostics, migrated when the config is loaded
 	Environment string
 }
 
 // ============================================================================
 
-// IsValid is used to validate the plugin configuration
+// IsValid is used to validate the plugin configuration, every problem is
+// returned at once with the YAML path at fault
 func (c *Config) IsValid() error {
 	// Validate Config
 	c.Hostname = strings.TrimSpace(c.Hostname)
@@ -32,29 +57,99 @@ func (c *Config) IsValid() error {
 	c.Hostname = strings.Replace(c.Hostname, "https://", "wss://", 1)
 	c.Hostname = strings.Replace(c.Hostname, "http://", "ws://", 1)
 
-	if len(c.Hostname) < 3 {
-		return errors.New("hostname too short")
+	v := &validation{}
+	if c.Hostname == "" {
+		v.add("hostname", errors.New("the hostname is required"))
+	} else {
+		v.url("hostname", c.Hostname, "ws", "wss")
 	}
-	if c.Hostname[0] != 'w' || c.Hostname[1] != 's' {
-		return errors.New("invalid hostname")
+	if c.Client != nil {
+		v.add("client", c.Client.isValid())
+	} else if c.Token == "" {
+		v.add("token", errors.New("the token or client is required"))
+	} else {
+		token, err := resolveSecret(c.Token)
+		if err != nil {
+			v.addf("token", "the token could not be resolved: %w", err)
+		} else if token == "" {
+			v.add("token", errors.New("the resolved token is empty"))
+		}
 	}
-	if c.Hostname == "" {
-		return errors.New("the hostname is not valid")
+	if c.Environment != "" {
+		v.add("environment", errors.New("environment is replaced by diagnostics"))
 	}
-	if c.Token == "" {
-		return errors.New("the token is not valid")
+	v.add("diagnostics", c.Diagnostics.isValid())
+
+	v.add("output", c.Output.isValid())
+
+	// validate smtp, the smtp server is only needed when delivering through it
+	if c.Output.isSmtp() {
+		v.add("smtp", c.Smtp.isValid())
+	} else {
+		v.add("smtp", c.Smtp.isValidSender())
 	}
-	if c.Environment != "production" && c.Environment != "development" {
-		return errors.New("the environment is not valid")
+
+	for i := range c.Relays {
+		v.add(fmt.Sprintf("relays[%d]", i), c.Relays[i].isValidRelay())
 	}
+	v.add("failover", c.Failover.isValid())
+	v.add("images", c.Images.isValid())
 
-	// validate smtp
-	err := c.Smtp.isValid()
-	if err != nil {
-		return fmt.Errorf("smtp is invalid: %w", err)
+	if c.BaseURL != nil {
+		v.url("baseurl", *c.BaseURL, "http", "https")
+	}
+	for i := range c.Actions {
+		v.add(fmt.Sprintf("actions[%d]", i), c.Actions[i].isValid())
 	}
 
-	return nil
+	if c.Threading != nil {
+		v.add("threading", c.Threading.isValid())
+	}
+	if c.QuietHours != nil {
+		v.add("quiethours", c.QuietHours.isValid())
+		c.QuietHours.fallback = c.location()
+	}
+	if c.Escalation != nil {
+		v.add("escalation", c.Escalation.isValid())
+	}
+	for i := range c.Filters {
+		v.add(fmt.Sprintf("filters[%d]", i), c.Filters[i].isValid())
+	}
+
+	if c.Timezone != nil {
+		_, err := time.LoadLocation(*c.Timezone)
+		if err != nil {
+			v.addf("timezone", "the timezone %q is not valid", *c.Timezone)
+		}
+	}
+	for _, name := range sortedKeys(c.Templates) {
+		t := c.Templates[name]
+		v.add("templates."+name, t.isValid())
+		c.Templates[name] = t
+	}
+	smtpTransport := false
+	for _, name := range sortedKeys(c.Transports) {
+		o := c.Transports[name]
+		v.add("transports."+name, o.isValid())
+		smtpTransport = smtpTransport || o.isSmtp()
+	}
+	// the smtp server is also needed when only a route delivers through it
+	if smtpTransport && !c.Output.isSmtp() {
+		v.add("smtp", c.Smtp.isValidRelay())
+	}
+	for i := range c.Routes {
+		v.add(fmt.Sprintf("routes[%d]", i), c.Routes[i].isValid(c))
+	}
+
+	return v.err()
+}
+
+// ============================================================================
+
+// httpURL is used to get the Gotify REST API url from the websocket hostname
+func (c *Config) httpURL() string {
+	uri := strings.Replace(c.Hostname, "wss://", "https://", 1)
+	return strings.Replace(uri, "ws://", "http://", 1)
 }
 
 // ============================================================================
@@ -65,12 +160,13 @@ func (c *Plugin) DefaultConfig() interface{} {
 		name := "Gotify SMTP Emailer"
 		email := "from@email.com"
 		return &Config{
+			Version:  currentConfigVersion,
 			Hostname: "ws://localhost",
 			Token:    "",
 			Smtp: Smtp{
 				Host:     "mailhog",
 				Port:     1025,
-				Insecure: true,
+				TLSMode:  tlsNone,
 				Username: "username@email.com",
 				From: EmailFrom{
 					Name:  &name,
@@ -78,21 +174,25 @@ func (c *Plugin) DefaultConfig() interface{} {
 				},
 				ToEmails: []string{"to@email.com"},
 			},
-			Environment: "development",
+			Diagnostics: Diagnostics{
+				LogLevel:     logDebug,
+				Notices:      true,
+				ReportErrors: reportAll,
+			},
 		}
 	}
 	return &Config{
+		Version:  currentConfigVersion,
 		Hostname: "ws://localhost",
 		Token:    "",
 		Smtp: Smtp{
 			Host:     "smtp.example.com",
 			Port:     587,
-			Insecure: false,
+			TLSMode:  tlsStartTLS,
 			Username: "username@email.com",
 			From:     EmailFrom{},
 			ToEmails: []string{"to@email.com"},
 		},
-		Environment: "production",
 	}
 }
 
@@ -100,25 +200,52 @@ func (c *Plugin) DefaultConfig() interface{} {
 
 // ValidateAndSetConfig is called when the user saves the config
 func (c *Plugin) ValidateAndSetConfig(in any) error {
+	// Gotify loads the stored config with the first call, whether or not it
+	// is valid, every later call is a save
+	loading := !c.configLoaded
+	c.configLoaded = true
+
 	config, ok := in.(*Config)
 	if !ok {
 		return errors.New("invalid config")
 	}
 
-	if config.Environment == "development" {
+	version := config.Version
+	changes, err := config.migrate()
+	if err != nil {
+		return fmt.Errorf("config could not be migrated: %w", err)
+	}
+
+	if config.Diagnostics.debug() {
 		b, _ := json.MarshalIndent(config, "", " ")
 		log.Println("SMTP Emailer: updating config:")
 		log.Println(string(b))
 	}
 
-	err := config.IsValid()
+	err = config.IsValid()
 	if err != nil {
-		return fmt.Errorf("config is invalid: %w", err)
+		return fmt.Errorf("config is invalid:\n%w", err)
+	}
+
+	// the stored config is only verified when it is saved so an outage does
+	// not prevent loading it
+	if config.VerifyOnSave && !loading {
+		err = c.verify(config)
+		if err != nil {
+			return fmt.Errorf("config could not be verified:\n%w", err)
+		}
 	}
 
 	log.Println("SMTP Emailer: updated config")
+	if len(changes) > 0 {
+		log.Printf("SMTP Emailer: migrated config from version %d to %d\n", version, config.Version)
+		c.notifyMigration(version, config.Version, changes)
+	}
 
 	c.config = config
+	c.transport = newTransport(config)
+	c.transports = newTransports(config)
+	c.setDryRun(config.DryRun)
 
 	return nil
 }
@@ -12,7 +12,7 @@ var baseConfig = Config{
 	Smtp: Smtp{
 		Host:     "smtp.host.com",
 		Port:     587,
-		Insecure: false,
+		TLSMode:  tlsStartTLS,
 		Username: "from@email.com",
 		Password: toPtr("password"),
 		Subject:  toPtr("Test Subject"),
@@ -22,7 +22,6 @@ var baseConfig = Config{
 		},
 		ToEmails: []string{"to@email.com"},
 	},
-	Environment: "production",
 }
 
 func TestConfigIsValid(t *testing.T) {
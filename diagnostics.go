@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"text/template"
+	"time"
+
+	"github.com/gotify/plugin-api"
+)
+
+const (
+	logInfo  = "info"
+	logDebug = "debug"
+
+	reportNone     = "none"
+	reportDelivery = "delivery"
+	reportAll      = "all"
+
+	defaultHeartbeatTitle   = "Heartbeat"
+	defaultHeartbeatMessage = "Plugin is running, uptime {{.Uptime}}"
+)
+
+// Diagnostics represents how the plugin reports on itself
+type Diagnostics struct {
+	LogLevel  string     // Optional: info or debug, debug also logs saved configs, defaults to info
+	Notices   bool       // Optional: send a Gotify message when the plugin is enabled or disabled
+	Heartbeat *Heartbeat // Optional: send a Gotify message on an interval
+	// Optional: none, delivery or all, which errors are sent as Gotify
+	// messages, delivery sends errors of connecting and emailing, all also
+	// sends errors of reading messages and closing the connection, defaults
+	// to delivery
+	ReportErrors string
+}
+
+// Heartbeat represents a Gotify message sent on an interval to show the
+// plugin is running
+type Heartbeat struct {
+	IntervalSeconds int     // Seconds between heartbeats
+	Title           *string // Optional: title of the heartbeat, defaults to Heartbeat
+	// Optional: template of the heartbeat message with {{.Time}} and
+	// {{.Uptime}}, defaults to Plugin is running, uptime {{.Uptime}}
+	Message *string
+	// Optional: email the heartbeat like any other message to test delivery
+	// end to end
+	Email bool
+}
+
+// heartbeatData represents the values available to the heartbeat template
+type heartbeatData struct {
+	Time   time.Time
+	Uptime time.Duration
+}
+
+// ============================================================================
+
+// isValid is used to validate the diagnostics configuration
+func (d *Diagnostics) isValid() error {
+	v := &validation{}
+	switch d.LogLevel {
+	case "", logInfo, logDebug:
+	default:
+		v.addf("loglevel", "the log level %q is not info or debug", d.LogLevel)
+	}
+	switch d.ReportErrors {
+	case "", reportNone, reportDelivery, reportAll:
+	default:
+		v.addf("reporterrors", "the report errors %q is not none, delivery or all", d.ReportErrors)
+	}
+	if d.Heartbeat != nil {
+		v.add("heartbeat", d.Heartbeat.isValid())
+	}
+
+	return v.err()
+}
+
+// debug is used to check if debug logging is enabled
+func (d *Diagnostics) debug() bool {
+	return d.LogLevel == logDebug
+}
+
+// reports is used to check if an error is sent as a Gotify message, verbose
+// errors are only sent when all errors are reported
+func (d *Diagnostics) reports(verbose bool) bool {
+	switch d.ReportErrors {
+	case reportNone:
+		return false
+	case reportAll:
+		return true
+	default:
+		return !verbose
+	}
+}
+
+// isValid is used to validate the heartbeat configuration
+func (h *Heartbeat) isValid() error {
+	v := &validation{}
+	if h.IntervalSeconds < 1 {
+		v.addf("intervalseconds", "the interval %d is not at least 1", h.IntervalSeconds)
+	}
+	if h.Message != nil {
+		_, err := template.New("heartbeat").Parse(*h.Message)
+		if err != nil {
+			v.addf("message", "the message is not valid: %w", err)
+		}
+	}
+
+	return v.err()
+}
+
+// message is used to get the heartbeat Gotify message, heartbeats that are
+// not emailed are titled like the other messages of the plugin so they are
+// skipped
+func (h *Heartbeat) message(data heartbeatData) (plugin.Message, error) {
+	title := defaultHeartbeatTitle
+	if h.Title != nil {
+		title = *h.Title
+	}
+	if !h.Email {
+		title = "SMTP Emailer: " + title
+	}
+
+	text := defaultHeartbeatMessage
+	if h.Message != nil {
+		text = *h.Message
+	}
+	tmpl, err := template.New("heartbeat").Parse(text)
+	if err != nil {
+		return plugin.Message{}, fmt.Errorf("could not parse heartbeat: %w", err)
+	}
+	var message bytes.Buffer
+	err = tmpl.Execute(&message, data)
+	if err != nil {
+		return plugin.Message{}, fmt.Errorf("could not execute heartbeat: %w", err)
+	}
+
+	return plugin.Message{Title: title, Message: message.String()}, nil
+}
+
+// ============================================================================
+
+// notice is used to send a lifecycle notice when notices are enabled
+func (c *Plugin) notice(title, message string) {
+	if c.msgHandler == nil || c.config == nil || !c.config.Diagnostics.Notices {
+		return
+	}
+
+	c.msgHandler.SendMessage(plugin.Message{Title: "SMTP Emailer: " + title, Message: message})
+}
+
+// reportError is used to send an error as a Gotify message when it is
+// reported, errors are always reported before a config is set
+func (c *Plugin) reportError(message string, verbose bool) {
+	if c.msgHandler == nil {
+		return
+	}
+	if c.config != nil && !c.config.Diagnostics.reports(verbose) {
+		return
+	}
+
+	c.msgHandler.SendMessage(plugin.Message{Title: "SMTP Emailer: Error", Message: message})
+}
+
+// handleHeartbeat is used to send heartbeats until done is closed
+func (c *Plugin) handleHeartbeat(done <-chan bool, h *Heartbeat) {
+	started := time.Now()
+	ticker := time.NewTicker(time.Duration(h.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		msg, err := h.message(heartbeatData{Time: now, Uptime: now.Sub(started).Round(time.Second)})
+		if err == nil {
+			err = c.msgHandler.SendMessage(msg)
+		}
+		if err != nil {
+			log.Printf("SMTP Emailer: could not send heartbeat: %v\n", err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
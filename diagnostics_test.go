@@ -0,0 +1,155 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gotify/plugin-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnosticsIsValid(t *testing.T) {
+	tests := []struct {
+		name        string
+		diagnostics Diagnostics
+		valid       bool
+	}{
+		{name: "should accept defaults", valid: true},
+		{
+			name: "should accept every option",
+			diagnostics: Diagnostics{
+				LogLevel:     logDebug,
+				Notices:      true,
+				Heartbeat:    &Heartbeat{IntervalSeconds: 60, Message: toPtr("up {{.Uptime}}")},
+				ReportErrors: reportAll,
+			},
+			valid: true,
+		},
+		{name: "should not accept unknown log level", diagnostics: Diagnostics{LogLevel: "trace"}},
+		{name: "should not accept unknown report errors", diagnostics: Diagnostics{ReportErrors: "some"}},
+		{name: "should not accept heartbeat without interval", diagnostics: Diagnostics{Heartbeat: &Heartbeat{}}},
+		{
+			name:        "should not accept invalid heartbeat template",
+			diagnostics: Diagnostics{Heartbeat: &Heartbeat{IntervalSeconds: 60, Message: toPtr("{{.Uptime")}},
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			err := tt.diagnostics.isValid()
+			if tt.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestDiagnosticsReports(t *testing.T) {
+	tests := []struct {
+		name         string
+		reportErrors string
+		delivery     bool
+		verbose      bool
+	}{
+		{name: "should report delivery errors by default", delivery: true},
+		{name: "should report delivery errors", reportErrors: reportDelivery, delivery: true},
+		{name: "should report all errors", reportErrors: reportAll, delivery: true, verbose: true},
+		{name: "should report no errors", reportErrors: reportNone},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			d := Diagnostics{ReportErrors: tt.reportErrors}
+			require.Equal(t, tt.delivery, d.reports(false))
+			require.Equal(t, tt.verbose, d.reports(true))
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestHeartbeatMessage(t *testing.T) {
+	data := heartbeatData{Time: time.Now(), Uptime: 90 * time.Second}
+
+	msg, err := (&Heartbeat{IntervalSeconds: 60}).message(data)
+	require.NoError(t, err)
+	require.Equal(t, "SMTP Emailer: Heartbeat", msg.Title)
+	require.Equal(t, "Plugin is running, uptime 1m30s", msg.Message)
+	t.Logf("\tP\tshould default the heartbeat and skip emailing it")
+
+	msg, err = (&Heartbeat{IntervalSeconds: 60, Title: toPtr("Ping"), Message: toPtr("up {{.Uptime}}"), Email: true}).message(data)
+	require.NoError(t, err)
+	require.Equal(t, "Ping", msg.Title)
+	require.Equal(t, "up 1m30s", msg.Message)
+	t.Logf("\tP\tshould email a custom heartbeat")
+}
+
+// channelMessages implements plugin.MessageHandler
+// It is used to receive the messages sent by goroutines of the plugin
+type channelMessages chan plugin.Message
+
+func (m channelMessages) SendMessage(msg plugin.Message) error {
+	m <- msg
+	return nil
+}
+
+func TestHandleHeartbeat(t *testing.T) {
+	messages := make(channelMessages, 1)
+	p := &Plugin{}
+	p.SetMessageHandler(messages)
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		p.handleHeartbeat(done, &Heartbeat{IntervalSeconds: 60})
+		close(stopped)
+	}()
+
+	select {
+	case msg := <-messages:
+		require.Equal(t, "SMTP Emailer: Heartbeat", msg.Title)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no heartbeat was sent")
+	}
+	t.Logf("\tP\tshould send a heartbeat right away")
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("heartbeat did not stop")
+	}
+	t.Logf("\tP\tshould stop when done is closed")
+}
+
+func TestPluginDiagnostics(t *testing.T) {
+	messages := &memoryMessages{}
+	p := &Plugin{}
+	p.SetMessageHandler(messages)
+
+	p.reportError("no config set", false)
+	require.Len(t, messages.sent, 1)
+	t.Logf("\tP\tshould report errors before a config is set")
+
+	cfg := baseConfig
+	p.config = &cfg
+	p.notice("Enabled", "Plugin has been enabled")
+	p.reportError("could not read message", true)
+	require.Len(t, messages.sent, 1)
+	p.reportError("smtp send error", false)
+	require.Len(t, messages.sent, 2)
+	t.Logf("\tP\tshould only report delivery errors by default")
+
+	cfg.Diagnostics = Diagnostics{Notices: true, ReportErrors: reportNone}
+	p.notice("Enabled", "Plugin has been enabled")
+	p.reportError("smtp send error", false)
+	require.Len(t, messages.sent, 3)
+	require.Equal(t, "SMTP Emailer: Enabled", messages.sent[2].Title)
+	t.Logf("\tP\tshould send notices when enabled")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+var defaultDKIMHeaders = []string{
+	"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-ID", "In-Reply-To", "References",
+	"MIME-Version", "Content-Type", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// DKIM represents the options for signing emails with DKIM
+type DKIM struct {
+	Domain     string
+	Selector   string
+	PrivateKey string // PEM encoded RSA or Ed25519 key, may be a file: or env: reference
+	// Optional: signed headers, defaults to From, To, Cc, Reply-To, Subject,
+	// Date, Message-ID, In-Reply-To, References, MIME-Version, Content-Type,
+	// List-Unsubscribe and List-Unsubscribe-Post
+	Headers []string
+}
+
+// ============================================================================
+
+// isValid is used to validate the DKIM configuration
+func (d *DKIM) isValid() error {
+	v := &validation{}
+	if d.Domain == "" {
+		v.add("domain", errors.New("the domain is required"))
+	}
+	if d.Selector == "" {
+		v.add("selector", errors.New("the selector is required"))
+	}
+	if d.Headers != nil {
+		from := false
+		for _, h := range d.Headers {
+			if strings.EqualFold(h, "From") {
+				from = true
+			}
+		}
+		if !from {
+			v.add("headers", errors.New("the headers must include From"))
+		}
+	}
+	_, err := d.signer()
+	if err != nil {
+		v.addf("privatekey", "the private key is not valid: %w", err)
+	}
+
+	return v.err()
+}
+
+// ============================================================================
+
+// signer is used to parse the DKIM private key
+func (d *DKIM) signer() (crypto.Signer, error) {
+	key, err := resolveSecret(d.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key")
+		}
+		switch signer.Public().(type) {
+		case *rsa.PublicKey, ed25519.PublicKey:
+			return signer, nil
+		default:
+			return nil, errors.New("only RSA and Ed25519 keys are supported")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// sign is used to sign a raw email, the signed email is returned
+func (d *DKIM) sign(content []byte) ([]byte, error) {
+	signer, err := d.signer()
+	if err != nil {
+		return nil, fmt.Errorf("could not parse dkim private key: %w", err)
+	}
+
+	headers := d.Headers
+	if headers == nil {
+		headers = defaultDKIMHeaders
+	}
+
+	var signed bytes.Buffer
+	err = dkim.Sign(&signed, bytes.NewReader(content), &dkim.SignOptions{
+		Domain:                 d.Domain,
+		Selector:               d.Selector,
+		Signer:                 signer,
+		Hash:                   crypto.SHA256,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             headers,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not sign email: %w", err)
+	}
+
+	return signed.Bytes(), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDKIMSign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edPrivate, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		key    string
+		record string
+	}{
+		{
+			name: "should sign with rsa key",
+			key: string(pem.EncodeToMemory(&pem.Block{
+				Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+			})),
+			record: "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPublic),
+		},
+		{
+			name:   "should sign with ed25519 key",
+			key:    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edPrivate})),
+			record: "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPublic),
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			s := baseConfig.Smtp
+			s.ReplyTo = []string{"help@email.com"}
+			s.ListUnsubscribe = []string{"https://email.com/unsubscribe"}
+			s.ListUnsubscribePost = true
+			s.DKIM = &DKIM{
+				Domain:     "email.com",
+				Selector:   "gotify",
+				PrivateKey: tt.key,
+			}
+			require.NoError(t, s.isValid())
+
+			email := &Email{Subject: "test title", HTML: "<p>test message</p>", Text: "test message", InReplyTo: "<root@email.com>"}
+			messages, err := s.build(email)
+			require.NoError(t, err)
+			require.Len(t, messages, 1)
+			content := messages[0].content
+
+			verifications, err := dkim.VerifyWithOptions(bytes.NewReader(content), &dkim.VerifyOptions{
+				LookupTXT: func(domain string) ([]string, error) {
+					require.Equal(t, "gotify._domainkey.email.com", domain)
+					return []string{tt.record}, nil
+				},
+			})
+			require.NoError(t, err)
+			require.Len(t, verifications, 1)
+			require.NoError(t, verifications[0].Err)
+			require.Equal(t, "email.com", verifications[0].Domain)
+			require.Contains(t, string(content), "c=relaxed/relaxed")
+
+			msg, err := mail.ReadMessage(bytes.NewReader(content))
+			require.NoError(t, err)
+			var signed []string
+			for _, tag := range strings.Split(msg.Header.Get("DKIM-Signature"), ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(tag), "=")
+				if name == "h" {
+					for _, header := range strings.Split(value, ":") {
+						signed = append(signed, strings.ToLower(strings.TrimSpace(header)))
+					}
+				}
+			}
+			for _, header := range []string{"reply-to", "in-reply-to", "references", "list-unsubscribe", "list-unsubscribe-post"} {
+				require.Contains(t, signed, header)
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+
+	t.Run("should not accept headers without from", func(t *testing.T) {
+		d := DKIM{Domain: "email.com", Selector: "gotify", PrivateKey: tests[0].key, Headers: []string{"Subject"}}
+		require.Error(t, d.isValid())
+	})
+}
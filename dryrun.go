@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxRecorded is the number of emails kept in dry run mode, the oldest
+	// emails are forgotten first
+	maxRecorded = 50
+
+	// maxDisplayed is the number of recorded emails listed in the display
+	maxDisplayed = 10
+)
+
+// recordedEmail represents an email recorded instead of delivered
+type recordedEmail struct {
+	Time    time.Time `json:"time"`
+	From    string    `json:"from"`
+	To      []string  `json:"to"`
+	Subject string    `json:"subject"`
+	Content string    `json:"content"`
+}
+
+// recorder implements Transport
+// It is used to record emails instead of delivering them in dry run mode
+type recorder struct {
+	mu     sync.Mutex
+	emails []recordedEmail
+}
+
+// ============================================================================
+
+// Deliver implements Transport
+// It is used to record a raw email
+func (r *recorder) Deliver(from string, to []string, content []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.emails = append(r.emails, recordedEmail{
+		Time:    time.Now(),
+		From:    from,
+		To:      append([]string{}, to...),
+		Subject: subject(content),
+		Content: string(content),
+	})
+	if len(r.emails) > maxRecorded {
+		r.emails = r.emails[len(r.emails)-maxRecorded:]
+	}
+	log.Printf("SMTP Emailer: dry run, recorded email to %s\n", strings.Join(to, ", "))
+
+	return nil
+}
+
+// recent is used to get the recorded emails, newest first
+func (r *recorder) recent() []recordedEmail {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	emails := make([]recordedEmail, 0, len(r.emails))
+	for i := len(r.emails) - 1; i >= 0; i-- {
+		emails = append(emails, r.emails[i])
+	}
+
+	return emails
+}
+
+// ============================================================================
+
+// setDryRun is used to replace the transports with the recorder in dry run
+// mode, recorded emails are kept while dry run stays enabled
+func (c *Plugin) setDryRun(enabled bool) {
+	if !enabled {
+		c.recorder = nil
+		return
+	}
+
+	if c.recorder == nil {
+		c.recorder = &recorder{}
+	}
+	c.transport = c.recorder
+	for name := range c.transports {
+		c.transports[name] = c.recorder
+	}
+}
+
+// handleDryRun is used to list the recorded emails as JSON
+func (c *Plugin) handleDryRun(ctx *gin.Context) {
+	if c.recorder == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "dry run is not enabled"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.recorder.recent())
+}
+
+// displayDryRun is used to list the latest recorded emails in the display
+func (c *Plugin) displayDryRun(location *url.URL) string {
+	if c.recorder == nil {
+		return ""
+	}
+
+	var display strings.Builder
+	display.WriteString("\n\n## Dry run\n\n")
+	display.WriteString("Emails are recorded instead of sent.")
+	if location != nil && c.basePath != "" {
+		link := location.ResolveReference(&url.URL{Path: c.basePath + "dryrun"})
+		display.WriteString(fmt.Sprintf(" All recorded emails are listed at [%s](%s).", link, link))
+	}
+	display.WriteString("\n\n")
+
+	emails := c.recorder.recent()
+	if len(emails) == 0 {
+		display.WriteString("No emails have been recorded yet.\n")
+		return display.String()
+	}
+	if len(emails) > maxDisplayed {
+		emails = emails[:maxDisplayed]
+	}
+
+	display.WriteString("| Time | To | Subject |\n")
+	display.WriteString("| --- | --- | --- |\n")
+	for _, e := range emails {
+		display.WriteString(fmt.Sprintf("| %s | %s | %s |\n", e.Time.Format(time.RFC3339),
+			escapeTableCell(strings.Join(e.To, ", ")), escapeTableCell(e.Subject)))
+	}
+
+	return display.String()
+}
+
+// subject is used to get the decoded subject of a raw email
+func subject(content []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(content))
+	if err != nil {
+		return ""
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return msg.Header.Get("Subject")
+	}
+
+	return subject
+}
+
+// escapeTableCell is used to keep a value in one markdown table cell
+func escapeTableCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.Join(strings.Fields(s), " ")
+}
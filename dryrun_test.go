@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gotify/plugin-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	cfg := baseConfig
+	cfg.DryRun = true
+	cfg.Threading = &Threading{}
+	cfg.Escalation = &Escalation{Tiers: []EscalationTier{{AfterMinutes: 10, ToEmails: []string{"lead@email.com"}}}}
+	cfg.Transports = map[string]Output{"archive": {Type: outputEml, Path: dir}}
+	cfg.Routes = []Route{{When: "priority >= 8", ToEmails: []string{"ops@email.com"}, Transport: toPtr("archive")}}
+
+	p := &Plugin{userCtx: plugin.UserContext{Admin: true}}
+	p.SetStorageHandler(&memoryStorage{})
+	require.NoError(t, p.ValidateAndSetConfig(&cfg))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	p.RegisterWebhook("/plugin/1/custom/token/", router.Group("/plugin/1/custom/token/"))
+
+	require.NoError(t, p.send(Message{Title: "disk | full", Message: "test message", Priority: 9}))
+	require.NoError(t, p.send(Message{Title: "deployed", Message: "test message", Priority: 2}))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	t.Logf("\tP\tshould not deliver emails")
+
+	data, err := p.loadStorage()
+	require.NoError(t, err)
+	require.Empty(t, data.Threads)
+	require.Empty(t, data.Escalations)
+	t.Logf("\tP\tshould not keep threads or escalations of recorded emails")
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/plugin/1/custom/token/dryrun", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	var emails []recordedEmail
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &emails))
+	require.Len(t, emails, 2)
+	require.Equal(t, []string{"to@email.com"}, emails[0].To)
+	require.Equal(t, "Test Subject: deployed", emails[0].Subject)
+	require.Equal(t, []string{"ops@email.com"}, emails[1].To)
+	require.Contains(t, emails[1].Content, "test message")
+	t.Logf("\tP\tshould list recorded emails through webhook")
+
+	display := p.GetDisplay(&url.URL{Scheme: "https", Host: "gotify.example.com"})
+	require.Contains(t, display, "https://gotify.example.com/plugin/1/custom/token/dryrun")
+	require.Contains(t, display, "| Test Subject: disk \\| full |")
+	t.Logf("\tP\tshould list recorded emails in display")
+
+	cfg.DryRun = false
+	require.NoError(t, p.ValidateAndSetConfig(&cfg))
+	require.NoError(t, p.send(Message{Title: "disk full", Message: "test message", Priority: 9}))
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/plugin/1/custom/token/dryrun", nil))
+	require.Equal(t, http.StatusNotFound, res.Code)
+	t.Logf("\tP\tshould deliver emails once dry run is disabled")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultEscalationMinPriority = 8
+
+	// escalationInterval is how often escalated messages are checked for
+	escalationInterval = 30 * time.Second
+)
+
+// Escalation represents the policy for escalating high priority messages that
+// are not acknowledged by deleting them in Gotify
+type Escalation struct {
+	MinPriority *int             // Optional: lowest priority escalated, defaults to 8
+	Tiers       []EscalationTier // Recipients emailed in order while the message is not deleted
+}
+
+// EscalationTier represents recipients emailed when a message is not
+// acknowledged in time
+type EscalationTier struct {
+	AfterMinutes int      // Minutes after the previous email the tier is emailed
+	ToEmails     []string // Emails of the tier
+}
+
+// escalationState represents a persisted escalation of a message
+type escalationState struct {
+	Message Message
+	Routes  []int     `json:",omitempty"` // Routes the message matched when it was sent
+	Tier    int       // Next tier emailed
+	Due     time.Time // When the next tier is emailed
+}
+
+// ============================================================================
+
+// isValid is used to validate the escalation configuration
+func (e *Escalation) isValid() error {
+	v := &validation{}
+	if e.MinPriority != nil && (*e.MinPriority < 0 || *e.MinPriority > 10) {
+		v.addf("minpriority", "the min priority %d is not between 0 and 10", *e.MinPriority)
+	}
+	if len(e.Tiers) == 0 {
+		v.add("tiers", errors.New("at least one tier is needed"))
+	}
+	for i, tier := range e.Tiers {
+		path := fmt.Sprintf("tiers[%d]", i)
+		if tier.AfterMinutes < 1 {
+			v.addf(path+".afterminutes", "the after minutes %d is not at least 1", tier.AfterMinutes)
+		}
+		v.emails(path+".toemails", tier.ToEmails, true)
+	}
+
+	return v.err()
+}
+
+// escalates is used to check if a message is escalated
+func (e *Escalation) escalates(msg Message) bool {
+	minPriority := defaultEscalationMinPriority
+	if e.MinPriority != nil {
+		minPriority = *e.MinPriority
+	}
+
+	return msg.ID != 0 && msg.Priority >= minPriority
+}
+
+// ============================================================================
+
+// escalate is used to start the escalation of a message sent through the
+// routes
+func (c *Plugin) escalate(msg Message, routes []int) error {
+	due := time.Now().Add(time.Duration(c.config.Escalation.Tiers[0].AfterMinutes) * time.Minute)
+
+	return c.updateStorage(func(data *storageData) {
+		data.Escalations = append(data.Escalations, escalationState{Message: msg, Routes: routes, Due: due})
+	})
+}
+
+// checkEscalations is used to email the next tier of the due escalations,
+// escalations end when their message is deleted or all tiers are emailed
+func (c *Plugin) checkEscalations() error {
+	if c.config.Escalation == nil {
+		return nil
+	}
+	tiers := c.config.Escalation.Tiers
+
+	data, err := c.loadStorage()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ended := map[uint]bool{}
+	updated := map[uint]escalationState{}
+	var errs []error
+	for _, e := range data.Escalations {
+		if now.Before(e.Due) {
+			continue
+		}
+		if e.Tier >= len(tiers) {
+			ended[e.Message.ID] = true
+			continue
+		}
+
+		exists, err := c.messageExists(e.Message.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not check message %d: %w", e.Message.ID, err))
+			continue
+		}
+		if !exists {
+			log.Printf("SMTP Emailer: message %d was acknowledged\n", e.Message.ID)
+			ended[e.Message.ID] = true
+			continue
+		}
+
+		err = c.sendEscalation(e, tiers[e.Tier])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not escalate message %d: %w", e.Message.ID, err))
+			continue
+		}
+		log.Printf("SMTP Emailer: escalated message %d to tier %d\n", e.Message.ID, e.Tier)
+
+		e.Tier++
+		if e.Tier >= len(tiers) {
+			ended[e.Message.ID] = true
+			continue
+		}
+		e.Due = now.Add(time.Duration(tiers[e.Tier].AfterMinutes) * time.Minute)
+		updated[e.Message.ID] = e
+	}
+
+	err = c.updateStorage(func(data *storageData) {
+		escalations := data.Escalations[:0]
+		for _, e := range data.Escalations {
+			if ended[e.Message.ID] {
+				continue
+			}
+			if u, ok := updated[e.Message.ID]; ok {
+				e = u
+			}
+			escalations = append(escalations, e)
+		}
+		data.Escalations = escalations
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// sendEscalation is used to email a message to the recipients of a tier
+// through the routes the message was sent through
+func (c *Plugin) sendEscalation(e escalationState, tier EscalationTier) error {
+	routes := e.Routes
+	if routes == nil {
+		// escalated before routes were kept with the message
+		routes = c.routes(e.Message)
+	}
+
+	email := c.render(e.Message)
+	var errs []error
+	for _, d := range c.routeDeliveries(routes) {
+		routed, err := c.routed(e.Message, email, d)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		routed.Subject = "Unacknowledged: " + routed.Subject
+
+		s := *d.smtp
+		s.ToEmails = tier.ToEmails
+		s.CcEmails = nil
+		err = c.deliver(d.transport, &s, routed)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// handleEscalations is used to escalate messages that are not acknowledged
+// until done is closed, it runs without escalation so it can be added while
+// the plugin is enabled
+func (c *Plugin) handleEscalations(done <-chan bool) {
+	ticker := time.NewTicker(escalationInterval)
+	defer ticker.Stop()
+
+	for {
+		err := c.checkEscalations()
+		if err != nil {
+			log.Printf("SMTP Emailer: could not check escalations: %v\n", err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
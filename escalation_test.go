@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscalationIsValid(t *testing.T) {
+	tests := []struct {
+		name       string
+		escalation Escalation
+		valid      bool
+	}{
+		{
+			name:       "should accept tiers",
+			escalation: Escalation{Tiers: []EscalationTier{{AfterMinutes: 5, ToEmails: []string{"lead@email.com"}}}},
+			valid:      true,
+		},
+		{name: "should not accept no tiers", escalation: Escalation{}},
+		{
+			name:       "should not accept tier without minutes",
+			escalation: Escalation{Tiers: []EscalationTier{{ToEmails: []string{"lead@email.com"}}}},
+		},
+		{name: "should not accept tier without emails", escalation: Escalation{Tiers: []EscalationTier{{AfterMinutes: 5}}}},
+		{
+			name:       "should not accept invalid email",
+			escalation: Escalation{Tiers: []EscalationTier{{AfterMinutes: 5, ToEmails: []string{"lead"}}}},
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			err := tt.escalation.isValid()
+			if tt.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	deleted := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/application" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		require.Equal(t, "/message", r.URL.Path)
+		require.Equal(t, "6", r.URL.Query().Get("since"))
+		if r.Header.Get("X-Gotify-Key") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if deleted {
+			fmt.Fprint(w, `{"messages":[{"id":4}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"messages":[{"id":5}]}`)
+	}))
+	defer srv.Close()
+
+	cfg := baseConfig
+	cfg.Hostname = strings.Replace(srv.URL, "http://", "ws://", 1)
+	cfg.Token = "token"
+	cfg.Escalation = &Escalation{Tiers: []EscalationTier{
+		{AfterMinutes: 5, ToEmails: []string{"lead@email.com"}},
+		{AfterMinutes: 10, ToEmails: []string{"manager@email.com"}},
+	}}
+	require.NoError(t, cfg.IsValid())
+	transport := &memoryTransport{}
+	p := &Plugin{config: &cfg, transport: transport}
+	p.SetStorageHandler(&memoryStorage{})
+
+	require.NoError(t, p.send(Message{ID: 4, Title: "low title", Priority: 5}))
+	require.NoError(t, p.send(Message{ID: 5, Title: "urgent title", Message: "urgent message", Priority: 9}))
+	data, err := p.loadStorage()
+	require.NoError(t, err)
+	require.Len(t, data.Escalations, 1)
+	require.Equal(t, uint(5), data.Escalations[0].Message.ID)
+	t.Logf("\tP\tshould escalate high priority messages")
+
+	require.NoError(t, p.checkEscalations())
+	require.Len(t, transport.sent, 2)
+	t.Logf("\tP\tshould not escalate before due")
+
+	due := func() {
+		require.NoError(t, p.updateStorage(func(data *storageData) {
+			data.Escalations[0].Due = time.Now().Add(-time.Second)
+		}))
+	}
+	due()
+	require.NoError(t, p.checkEscalations())
+	require.Len(t, transport.sent, 3)
+	require.Contains(t, string(transport.sent[2]), "To: lead@email.com")
+	require.Contains(t, string(transport.sent[2]), "Unacknowledged: urgent title")
+	data, err = p.loadStorage()
+	require.NoError(t, err)
+	require.Equal(t, 1, data.Escalations[0].Tier)
+	require.WithinDuration(t, time.Now().Add(10*time.Minute), data.Escalations[0].Due, time.Minute)
+	t.Logf("\tP\tshould email next tier")
+
+	deleted = true
+	due()
+	require.NoError(t, p.checkEscalations())
+	require.Len(t, transport.sent, 3)
+	data, err = p.loadStorage()
+	require.NoError(t, err)
+	require.Empty(t, data.Escalations)
+	t.Logf("\tP\tshould end escalation when message is deleted")
+
+	dir := t.TempDir()
+	deleted = false
+	cfg.Transports = map[string]Output{"archive": {Type: outputEml, Path: dir}}
+	cfg.Templates = map[string]Template{"pager": {Subject: toPtr("PAGE {{.Title}}")}}
+	cfg.Routes = []Route{{When: "priority >= 8", Template: toPtr("pager"), Transport: toPtr("archive")}}
+	require.NoError(t, cfg.IsValid())
+	p.transports = newTransports(&cfg)
+	require.NoError(t, p.send(Message{ID: 5, Title: "urgent title", Priority: 9}))
+	due()
+	require.NoError(t, p.checkEscalations())
+	require.Len(t, transport.sent, 3)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	var escalated []string
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		require.NoError(t, err)
+		if strings.Contains(string(content), "To: lead@email.com") {
+			escalated = append(escalated, string(content))
+		}
+	}
+	require.Len(t, escalated, 1)
+	require.Contains(t, escalated[0], "Unacknowledged: PAGE urgent title")
+	t.Logf("\tP\tshould escalate through the routes of the message")
+}
+
+func TestHandleEscalations(t *testing.T) {
+	cfg := baseConfig
+	p := &Plugin{config: &cfg, transport: &memoryTransport{}}
+	p.SetStorageHandler(&memoryStorage{})
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		p.handleEscalations(done)
+		close(stopped)
+	}()
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("escalations did not stop")
+	}
+	t.Logf("\tP\tshould run without escalation and stop when done is closed")
+}
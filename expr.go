@@ -0,0 +1,582 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The routing expression language combines comparisons of message fields with
+// and, or, not and parentheses, for example:
+//
+//	priority >= 8 or (app == 'backup' and title contains 'FAIL') and time between 09:00 and 18:00
+//
+// Fields are app, appid, priority, title, message, extras.<key>.<key>, time
+// and weekday. Comparisons are ==, !=, <, <=, >, >=, contains, matches
+// (regular expression), between x and y, and in (x, y).
+
+// valueKind represents the type of an expression value
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindNumber
+	kindAny // extras values are compared as numbers when they are numeric
+)
+
+// exprEnv represents what an expression is evaluated against
+type exprEnv struct {
+	msg Message
+	now time.Time     // Time in the routing timezone
+	app func() string // Name of the application of the message
+}
+
+// exprValue represents a value of an expression
+type exprValue struct {
+	str   string
+	num   float64
+	isNum bool
+}
+
+// expr represents a compiled boolean expression
+type expr interface {
+	eval(env *exprEnv) bool
+}
+
+// operand represents a field or a literal of an expression
+type operand interface {
+	value(env *exprEnv) (exprValue, bool)
+	kind() valueKind
+}
+
+// ============================================================================
+
+// compileExpr is used to compile a routing expression
+func compileExpr(s string) (expr, error) {
+	tokens, err := lexExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, end: len(s)}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+
+	return e, nil
+}
+
+// ============================================================================
+
+const (
+	tokenIdent = iota
+	tokenString
+	tokenNumber
+	tokenClock
+	tokenSymbol
+)
+
+// exprToken represents a token of an expression
+type exprToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+var clockPattern = regexp.MustCompile(`^\d{1,2}:\d{2}`)
+
+// lexExpr is used to split an expression into tokens
+func lexExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			var text strings.Builder
+			j := i + 1
+			for ; j < len(s) && rune(s[j]) != r; j++ {
+				// only quotes and backslashes are escaped so patterns keep theirs
+				if s[j] == '\\' && j+1 < len(s) && (rune(s[j+1]) == r || s[j+1] == '\\') {
+					j++
+				}
+				text.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, exprToken{tokenString, text.String(), i})
+			i = j + 1
+		case unicode.IsDigit(r):
+			if clock := clockPattern.FindString(s[i:]); clock != "" {
+				tokens = append(tokens, exprToken{tokenClock, clock, i})
+				i += len(clock)
+				continue
+			}
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, exprToken{tokenNumber, s[i:j], i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) ||
+				strings.ContainsRune("_.:-", rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, exprToken{tokenIdent, s[i:j], i})
+			i = j
+		default:
+			symbol := ""
+			for _, sym := range []string{"==", "!=", ">=", "<=", "&&", "||", ">", "<", "!", "(", ")", ","} {
+				if strings.HasPrefix(s[i:], sym) {
+					symbol = sym
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, fmt.Errorf("unexpected %q at position %d", r, i)
+			}
+			tokens = append(tokens, exprToken{tokenSymbol, symbol, i})
+			i += len(symbol)
+		}
+	}
+
+	return tokens, nil
+}
+
+// ============================================================================
+
+// exprParser is used to parse tokens into an expression
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	end    int // Position of the end of the expression
+}
+
+func (p *exprParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *exprParser) peek() exprToken {
+	if p.done() {
+		return exprToken{text: "end of expression", pos: p.end}
+	}
+	return p.tokens[p.pos]
+}
+
+// accept is used to consume the next token when it is one of the keywords or
+// symbols
+func (p *exprParser) accept(words ...string) bool {
+	if p.done() {
+		return false
+	}
+	t := p.tokens[p.pos]
+	if t.kind != tokenIdent && t.kind != tokenSymbol {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) {
+			p.pos++
+			return true
+		}
+	}
+
+	return false
+}
+
+// expect is used to consume a keyword or symbol that must follow
+func (p *exprParser) expect(word string) error {
+	if !p.accept(word) {
+		return fmt.Errorf("expected %q at position %d, got %q", word, p.peek().pos, p.peek().text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and", "&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (expr, error) {
+	if p.accept("not", "!") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	}
+	if p.accept("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (expr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+	switch {
+	case p.accept("==", "!=", "<", "<=", ">", ">="):
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if op.text == "==" || op.text == "!=" {
+			err = sameKind(left, right)
+		} else {
+			err = numeric(left, right)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w at position %d", err, op.pos)
+		}
+		return compareExpr{op.text, left, right}, nil
+	case p.accept("contains"):
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() == kindNumber || right.kind() == kindNumber {
+			return nil, fmt.Errorf("contains needs text at position %d", op.pos)
+		}
+		return containsExpr{left, right}, nil
+	case p.accept("matches"):
+		pattern := p.peek()
+		if pattern.kind != tokenString {
+			return nil, fmt.Errorf("matches needs a quoted pattern at position %d", pattern.pos)
+		}
+		p.pos++
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern at position %d: %w", pattern.pos, err)
+		}
+		if left.kind() == kindNumber {
+			return nil, fmt.Errorf("matches needs text at position %d", op.pos)
+		}
+		return matchesExpr{left, re}, nil
+	case p.accept("between"):
+		low, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		err = p.expect("and")
+		if err != nil {
+			return nil, err
+		}
+		high, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		err = numeric(left, low, high)
+		if err != nil {
+			return nil, fmt.Errorf("%w at position %d", err, op.pos)
+		}
+		return betweenExpr{left, low, high}, nil
+	case p.accept("in"):
+		err := p.expect("(")
+		if err != nil {
+			return nil, err
+		}
+		var list []operand
+		for {
+			item, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			err = sameKind(left, item)
+			if err != nil {
+				return nil, fmt.Errorf("%w at position %d", err, op.pos)
+			}
+			list = append(list, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+		return inExpr{left, list}, p.expect(")")
+	default:
+		return nil, fmt.Errorf("expected a comparison at position %d, got %q", op.pos, op.text)
+	}
+}
+
+func (p *exprParser) parseOperand() (operand, error) {
+	t := p.peek()
+	if p.done() {
+		return nil, fmt.Errorf("expected a field or value, got %s", t.text)
+	}
+	p.pos++
+
+	switch t.kind {
+	case tokenString:
+		return literal{exprValue{str: t.text}}, nil
+	case tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return literal{exprValue{str: t.text, num: n, isNum: true}}, nil
+	case tokenClock:
+		minutes, err := parseClock(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q at position %d", t.text, t.pos)
+		}
+		return literal{exprValue{str: t.text, num: float64(minutes), isNum: true}}, nil
+	case tokenIdent:
+		name := strings.ToLower(t.text)
+		if strings.HasPrefix(t.text, "extras.") {
+			name = t.text
+		}
+		f := field{name: name}
+		if _, ok := fieldKinds[name]; !ok && !isValidField(name) {
+			return nil, fmt.Errorf("unknown field %q at position %d", t.text, t.pos)
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("expected a field or value at position %d, got %q", t.pos, t.text)
+	}
+}
+
+// sameKind is used to check that operands can be compared for equality
+func sameKind(operands ...operand) error {
+	kinds := map[valueKind]bool{}
+	for _, o := range operands {
+		kinds[o.kind()] = true
+	}
+	if kinds[kindString] && kinds[kindNumber] {
+		return fmt.Errorf("can not compare text with a number")
+	}
+
+	return nil
+}
+
+// numeric is used to check that operands are numbers
+func numeric(operands ...operand) error {
+	for _, o := range operands {
+		if o.kind() == kindString {
+			return fmt.Errorf("can not order text")
+		}
+	}
+
+	return nil
+}
+
+// ============================================================================
+
+// fieldKinds are the types of the message fields, extras values are kindAny
+var fieldKinds = map[string]valueKind{
+	"app":      kindString,
+	"appid":    kindNumber,
+	"priority": kindNumber,
+	"title":    kindString,
+	"message":  kindString,
+	"time":     kindNumber,
+	"weekday":  kindString,
+}
+
+// field represents a message field of an expression
+type field struct {
+	name string
+}
+
+func (f field) kind() valueKind {
+	kind, ok := fieldKinds[f.name]
+	if !ok {
+		return kindAny
+	}
+	return kind
+}
+
+func (f field) value(env *exprEnv) (exprValue, bool) {
+	switch f.name {
+	case "app":
+		return exprValue{str: env.app()}, true
+	case "appid":
+		return numberValue(float64(env.msg.AppID)), true
+	case "priority":
+		return numberValue(float64(env.msg.Priority)), true
+	case "time":
+		return numberValue(float64(env.now.Hour()*60 + env.now.Minute())), true
+	case "weekday":
+		return exprValue{str: strings.ToLower(env.now.Weekday().String()[:3])}, true
+	}
+
+	s, ok := env.msg.field(f.name)
+	if !ok {
+		return exprValue{}, false
+	}
+	v := exprValue{str: s}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		v.num, v.isNum = n, true
+	}
+	return v, true
+}
+
+// literal represents a value written in an expression
+type literal struct {
+	v exprValue
+}
+
+func (l literal) kind() valueKind {
+	if l.v.isNum {
+		return kindNumber
+	}
+	return kindString
+}
+
+func (l literal) value(env *exprEnv) (exprValue, bool) {
+	return l.v, true
+}
+
+func numberValue(n float64) exprValue {
+	return exprValue{str: strconv.FormatFloat(n, 'f', -1, 64), num: n, isNum: true}
+}
+
+// ============================================================================
+
+type orExpr struct{ left, right expr }
+
+func (e orExpr) eval(env *exprEnv) bool { return e.left.eval(env) || e.right.eval(env) }
+
+type andExpr struct{ left, right expr }
+
+func (e andExpr) eval(env *exprEnv) bool { return e.left.eval(env) && e.right.eval(env) }
+
+type notExpr struct{ e expr }
+
+func (e notExpr) eval(env *exprEnv) bool { return !e.e.eval(env) }
+
+// compareExpr compares numbers when both values are numbers and text
+// otherwise, missing extras values never match
+type compareExpr struct {
+	op          string
+	left, right operand
+}
+
+func (e compareExpr) eval(env *exprEnv) bool {
+	l, ok := e.left.value(env)
+	if !ok {
+		return false
+	}
+	r, ok := e.right.value(env)
+	if !ok {
+		return false
+	}
+
+	if e.op == "==" || e.op == "!=" {
+		equal := l.str == r.str
+		if l.isNum && r.isNum {
+			equal = l.num == r.num
+		}
+		return equal == (e.op == "==")
+	}
+
+	if !l.isNum || !r.isNum {
+		return false
+	}
+	switch e.op {
+	case "<":
+		return l.num < r.num
+	case "<=":
+		return l.num <= r.num
+	case ">":
+		return l.num > r.num
+	default:
+		return l.num >= r.num
+	}
+}
+
+type containsExpr struct{ left, right operand }
+
+func (e containsExpr) eval(env *exprEnv) bool {
+	l, ok := e.left.value(env)
+	if !ok {
+		return false
+	}
+	r, ok := e.right.value(env)
+	return ok && strings.Contains(l.str, r.str)
+}
+
+type matchesExpr struct {
+	left operand
+	re   *regexp.Regexp
+}
+
+func (e matchesExpr) eval(env *exprEnv) bool {
+	l, ok := e.left.value(env)
+	return ok && e.re.MatchString(l.str)
+}
+
+// betweenExpr includes both ends, a range with a higher start than end wraps
+// around, for example time between 22:00 and 06:00
+type betweenExpr struct{ x, low, high operand }
+
+func (e betweenExpr) eval(env *exprEnv) bool {
+	x, ok := e.x.value(env)
+	if !ok || !x.isNum {
+		return false
+	}
+	low, _ := e.low.value(env)
+	high, _ := e.high.value(env)
+	if low.num <= high.num {
+		return x.num >= low.num && x.num <= high.num
+	}
+
+	return x.num >= low.num || x.num <= high.num
+}
+
+type inExpr struct {
+	x    operand
+	list []operand
+}
+
+func (e inExpr) eval(env *exprEnv) bool {
+	for _, item := range e.list {
+		if (compareExpr{"==", e.x, item}).eval(env) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExprEval(t *testing.T) {
+	msg := Message{
+		AppID:    3,
+		Title:    "Backup FAILED",
+		Message:  "disk full on db-1",
+		Priority: 5,
+		Extras: map[string]interface{}{
+			"host": map[string]interface{}{"name": "db-1", "load": 4.5},
+		},
+	}
+	env := &exprEnv{
+		msg: msg,
+		now: time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC),
+		app: func() string { return "backup" },
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: "priority >= 8", want: false},
+		{expr: "priority >= 8 OR (app == 'backup' AND title contains 'FAIL') and time between 09:00 and 18:00", want: true},
+		{expr: "priority >= 8 or (app == 'backup' and title contains 'FAIL') and time between 11:00 and 18:00", want: false},
+		{expr: "time between 22:00 and 11:00", want: true},
+		{expr: "not appid == 3", want: false},
+		{expr: "!(appid != 3) && priority < 6", want: true},
+		{expr: `message matches '^disk \w+'`, want: true},
+		{expr: "title matches '(?i)^backup failed$'", want: true},
+		{expr: "extras.host.name == 'db-1'", want: true},
+		{expr: "extras.host.load > 4", want: true},
+		{expr: "extras.host.missing == ''", want: false},
+		{expr: "weekday in ('sat', 'sun')", want: false},
+		{expr: "weekday in (\"mon\", \"wed\")", want: true},
+		{expr: "priority between 4 and 5", want: true},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.expr)
+			e, err := compileExpr(tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, e.eval(env))
+		}
+
+		t.Run(tt.expr, test)
+	}
+}
+
+func TestCompileExpr(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "should not accept unknown field", expr: "severity > 3"},
+		{name: "should not accept text compared to number", expr: "priority == 'high'"},
+		{name: "should not accept ordered text", expr: "title > 'a'"},
+		{name: "should not accept invalid pattern", expr: "title matches '(unclosed'"},
+		{name: "should not accept unquoted pattern", expr: "title matches foo"},
+		{name: "should not accept missing comparison", expr: "priority"},
+		{name: "should not accept unbalanced parentheses", expr: "(priority > 3"},
+		{name: "should not accept trailing tokens", expr: "priority > 3 priority"},
+		{name: "should not accept unterminated string", expr: "title == 'abc"},
+		{name: "should not accept invalid time", expr: "time > 25:00"},
+		{name: "should not accept unknown symbol", expr: "priority > 3 ; title == 'a'"},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			_, err := compileExpr(tt.expr)
+			require.Error(t, err)
+		}
+
+		t.Run(tt.name, test)
+	}
+}
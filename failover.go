@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFailoverMaxFailures     = 3
+	defaultFailoverCooldownSeconds = 300
+)
+
+// Failover represents the options for failing over between smtp relays
+type Failover struct {
+	MaxFailures     *int // Optional: consecutive failures before a relay is skipped, defaults to 3
+	CooldownSeconds *int // Optional: time a failing relay is skipped for, defaults to 300
+}
+
+// relayPool is used to deliver emails through the first healthy relay
+type relayPool struct {
+	mu          sync.Mutex
+	relays      []*Smtp
+	health      []relayHealth
+	maxFailures int
+	cooldown    time.Duration
+	now         func() time.Time
+}
+
+// relayHealth represents the recent failures of a relay
+type relayHealth struct {
+	failures  int
+	skipUntil time.Time
+}
+
+// ============================================================================
+
+// isValid is used to validate the failover configuration
+func (f *Failover) isValid() error {
+	v := &validation{}
+	if f.MaxFailures != nil && *f.MaxFailures < 1 {
+		v.addf("maxfailures", "the max failures %d is not at least 1", *f.MaxFailures)
+	}
+	if f.CooldownSeconds != nil && *f.CooldownSeconds < 0 {
+		v.addf("cooldownseconds", "the cooldown %d is negative", *f.CooldownSeconds)
+	}
+
+	return v.err()
+}
+
+// ============================================================================
+
+// newRelayPool is used to create a relay pool with the smtp server followed
+// by the fallback relays of the config
+func newRelayPool(c *Config) *relayPool {
+	p := relayPool{
+		relays:      []*Smtp{&c.Smtp},
+		maxFailures: defaultFailoverMaxFailures,
+		cooldown:    defaultFailoverCooldownSeconds * time.Second,
+		now:         time.Now,
+	}
+	for i := range c.Relays {
+		p.relays = append(p.relays, &c.Relays[i])
+	}
+	p.health = make([]relayHealth, len(p.relays))
+
+	if c.Failover.MaxFailures != nil {
+		p.maxFailures = *c.Failover.MaxFailures
+	}
+	if c.Failover.CooldownSeconds != nil {
+		p.cooldown = time.Duration(*c.Failover.CooldownSeconds) * time.Second
+	}
+
+	return &p
+}
+
+// ============================================================================
+
+// Deliver implements Transport
+// It is used to deliver a raw email through the first relay that
+// accepts it. Relays are failed over on every error but a rejected recipient,
+// relays that keep failing are skipped until their cooldown has passed.
+func (p *relayPool) Deliver(from string, to []string, content []byte) error {
+	var errs []error
+	for _, i := range p.order() {
+		relay := p.relays[i]
+		err := relay.Deliver(from, to, content)
+		if err == nil {
+			p.succeeded(i)
+			return nil
+		}
+		if !failsOver(err) {
+			return err
+		}
+
+		errs = append(errs, fmt.Errorf("relay %s:%d: %w", relay.Host, relay.Port, err))
+		p.failed(i)
+		log.Printf("SMTP Emailer: relay %s:%d failed: %v\n", relay.Host, relay.Port, err)
+	}
+
+	return fmt.Errorf("all relays failed: %w", errors.Join(errs...))
+}
+
+// order is used to get the relays in the order they are tried, relays in
+// their cooldown are only tried when all others have failed
+func (p *relayPool) order() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	order := make([]int, 0, len(p.relays))
+	var cooling []int
+	for i := range p.relays {
+		if p.health[i].skipUntil.After(now) {
+			cooling = append(cooling, i)
+			continue
+		}
+		order = append(order, i)
+	}
+
+	return append(order, cooling...)
+}
+
+// succeeded is used to reset the failures of a relay
+func (p *relayPool) succeeded(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.health[i] = relayHealth{}
+}
+
+// failed is used to count a failure of a relay, it is skipped once it failed
+// too often
+func (p *relayPool) failed(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.health[i].failures++
+	if p.health[i].failures >= p.maxFailures {
+		p.health[i].skipUntil = p.now().Add(p.cooldown)
+	}
+}
+
+// failsOver is used to check if an smtp error should be retried with another
+// relay. Connection, auth and sender errors are faults of the relay, only a
+// recipient that does not exist is rejected by every relay.
+func failsOver(err error) bool {
+	var rcptErr *recipientError
+	var protoErr *textproto.Error
+	if errors.As(err, &rcptErr) && errors.As(err, &protoErr) {
+		return protoErr.Code != 550 && protoErr.Code != 553
+	}
+
+	return true
+}
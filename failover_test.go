@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTP is a minimal smtp server without STARTTLS replying to MAIL FROM
+// with the given reply
+type fakeSMTP struct {
+	listener net.Listener
+	mailFrom string
+	password string // password accepted by AUTH CRAM-MD5, auth is not offered when empty
+	mu       sync.Mutex
+	rcptTo   string // reply to RCPT TO, defaults to 250 ok
+	messages []string
+}
+
+func newFakeSMTP(t *testing.T, mailFrom string) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeSMTP{listener: l, mailFrom: mailFrom}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *fakeSMTP) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) setMailFrom(reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mailFrom = reply
+}
+
+func (s *fakeSMTP) setRcptTo(reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcptTo = reply
+}
+
+func (s *fakeSMTP) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 fake ESMTP\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			if s.password != "" {
+				fmt.Fprint(conn, "250-fake\r\n250 AUTH CRAM-MD5\r\n")
+				continue
+			}
+			fmt.Fprint(conn, "250 fake\r\n")
+		case strings.HasPrefix(cmd, "AUTH CRAM-MD5"):
+			challenge := "<1@fake>"
+			fmt.Fprintf(conn, "334 %s\r\n", base64.StdEncoding.EncodeToString([]byte(challenge)))
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			b, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+			mac := hmac.New(md5.New, []byte(s.password))
+			mac.Write([]byte(challenge))
+			if strings.HasSuffix(string(b), " "+hex.EncodeToString(mac.Sum(nil))) {
+				fmt.Fprint(conn, "235 2.7.0 authenticated\r\n")
+				continue
+			}
+			fmt.Fprint(conn, "535 5.7.8 authentication failed\r\n")
+		case strings.HasPrefix(cmd, "MAIL FROM"):
+			s.mu.Lock()
+			reply := s.mailFrom
+			s.mu.Unlock()
+			fmt.Fprint(conn, reply+"\r\n")
+		case strings.HasPrefix(cmd, "RCPT TO"):
+			s.mu.Lock()
+			reply := s.rcptTo
+			s.mu.Unlock()
+			if reply == "" {
+				reply = "250 ok"
+			}
+			fmt.Fprint(conn, reply+"\r\n")
+		case cmd == "DATA":
+			fmt.Fprint(conn, "354 go ahead\r\n")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			fmt.Fprint(conn, "250 queued\r\n")
+		case cmd == "QUIT":
+			fmt.Fprint(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "250 ok\r\n")
+		}
+	}
+}
+
+// closedPort is used to get a local port nothing is listening on
+func closedPort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+	return port
+}
+
+func TestRelayPoolDeliver(t *testing.T) {
+	relay := func(port int) Smtp {
+		return Smtp{Host: "127.0.0.1", Port: port, TLSMode: tlsNone, Username: "from@email.com", ToEmails: []string{"to@email.com"}}
+	}
+	content := []byte("Subject: test\r\n\r\ntest message\r\n")
+
+	t.Run("should fail over to the next relay", func(t *testing.T) {
+		temporary := newFakeSMTP(t, "451 try again later")
+		backup := newFakeSMTP(t, "250 ok")
+
+		cfg := baseConfig
+		cfg.Smtp = relay(closedPort(t))
+		cfg.Relays = []Smtp{relay(temporary.port()), relay(backup.port())}
+		require.NoError(t, cfg.IsValid())
+
+		err := newRelayPool(&cfg).Deliver("from@email.com", []string{"to@email.com"}, content)
+		require.NoError(t, err)
+		require.Len(t, backup.received(), 1)
+		require.Empty(t, temporary.received())
+	})
+
+	t.Run("should fail over on relay faults", func(t *testing.T) {
+		rejecting := newFakeSMTP(t, "550 sender rejected")
+		locked := newFakeSMTP(t, "250 ok")
+		locked.password = "password"
+		backup := newFakeSMTP(t, "250 ok")
+
+		cfg := baseConfig
+		cfg.Smtp = relay(rejecting.port())
+		wrongPassword := relay(locked.port())
+		wrongPassword.Password = toPtr("wrong")
+		cfg.Relays = []Smtp{wrongPassword, relay(backup.port())}
+		require.NoError(t, cfg.IsValid())
+
+		err := newRelayPool(&cfg).Deliver("from@email.com", []string{"to@email.com"}, content)
+		require.NoError(t, err)
+		require.Len(t, backup.received(), 1)
+	})
+
+	t.Run("should not fail over on rejected recipients", func(t *testing.T) {
+		permanent := newFakeSMTP(t, "250 ok")
+		permanent.setRcptTo("550 no such user")
+		backup := newFakeSMTP(t, "250 ok")
+
+		cfg := baseConfig
+		cfg.Smtp = relay(permanent.port())
+		cfg.Relays = []Smtp{relay(backup.port())}
+
+		err := newRelayPool(&cfg).Deliver("from@email.com", []string{"to@email.com"}, content)
+		var rcptErr *recipientError
+		require.ErrorAs(t, err, &rcptErr)
+		require.Equal(t, "to@email.com", rcptErr.Rcpt)
+		require.Empty(t, backup.received())
+	})
+
+	t.Run("should skip failing relay until cooldown has passed", func(t *testing.T) {
+		primary := newFakeSMTP(t, "451 try again later")
+		backup := newFakeSMTP(t, "250 ok")
+
+		cfg := baseConfig
+		cfg.Smtp = relay(primary.port())
+		cfg.Relays = []Smtp{relay(backup.port())}
+		cfg.Failover = Failover{MaxFailures: toPtr(1), CooldownSeconds: toPtr(60)}
+
+		now := time.Now()
+		pool := newRelayPool(&cfg)
+		pool.now = func() time.Time { return now }
+
+		err := pool.Deliver("from@email.com", []string{"to@email.com"}, content)
+		require.NoError(t, err)
+		require.True(t, pool.health[0].skipUntil.After(now))
+
+		// the primary relay would accept the message if it was tried again
+		primary.setMailFrom("250 ok")
+		err = pool.Deliver("from@email.com", []string{"to@email.com"}, content)
+		require.NoError(t, err)
+		require.Len(t, backup.received(), 2)
+		require.Empty(t, primary.received())
+
+		now = now.Add(61 * time.Second)
+		err = pool.Deliver("from@email.com", []string{"to@email.com"}, content)
+		require.NoError(t, err)
+		require.Len(t, primary.received(), 1)
+		require.Len(t, backup.received(), 2)
+	})
+}
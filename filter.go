@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Filter represents rules deciding which messages are emailed
+type Filter struct {
+	AppID   *uint   // Optional: only filter messages of this application
+	Include []Match // Optional: messages must match one of these to be emailed
+	Exclude []Match // Optional: messages matching one of these are not emailed
+}
+
+// Match represents a regular expression matched on a message field
+type Match struct {
+	Field   string // title, message or an extras value like extras.client::notification.click.url
+	Pattern string // Regular expression, for example (?i)completed successfully
+
+	pattern *regexp.Regexp // compiled pattern, set by isValid
+}
+
+// ============================================================================
+
+// isValid is used to validate the filter configuration
+func (f *Filter) isValid() error {
+	v := &validation{}
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		v.add("", errors.New("the filter needs include or exclude matches"))
+	}
+	for i := range f.Include {
+		v.add(fmt.Sprintf("include[%d]", i), f.Include[i].isValid())
+	}
+	for i := range f.Exclude {
+		v.add(fmt.Sprintf("exclude[%d]", i), f.Exclude[i].isValid())
+	}
+
+	return v.err()
+}
+
+// isValid is used to validate the match configuration, the pattern is
+// compiled once here so messages are matched without compiling it again
+func (m *Match) isValid() error {
+	v := &validation{}
+	if !isValidField(m.Field) {
+		v.addf("field", "the field %q is not valid", m.Field)
+	}
+	pattern, err := regexp.Compile(m.Pattern)
+	if err != nil {
+		v.addf("pattern", "the pattern is not valid: %w", err)
+	}
+	m.pattern = pattern
+
+	return v.err()
+}
+
+// ============================================================================
+
+// allows is used to check if a message passes the filter, filters of other
+// applications allow every message
+func (f *Filter) allows(msg Message) bool {
+	if f.AppID != nil && *f.AppID != msg.AppID {
+		return true
+	}
+	if len(f.Include) > 0 && !matchesAny(f.Include, msg) {
+		return false
+	}
+
+	return !matchesAny(f.Exclude, msg)
+}
+
+// matches is used to check if the message field matches the pattern compiled
+// by isValid, missing fields do not match
+func (m *Match) matches(msg Message) bool {
+	if m.pattern == nil {
+		return false
+	}
+	value, ok := msg.field(m.Field)
+	if !ok {
+		return false
+	}
+
+	return m.pattern.MatchString(value)
+}
+
+// matchesAny is used to check if one of the matches matches the message
+func matchesAny(matches []Match, msg Message) bool {
+	for i := range matches {
+		if matches[i].matches(msg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filtered is used to check if a message is dropped by one of the filters
+func (c *Config) filtered(msg Message) bool {
+	for i := range c.Filters {
+		if !c.Filters[i].allows(msg) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFiltered(t *testing.T) {
+	backupApp := uint(2)
+	cfg := baseConfig
+	cfg.Filters = []Filter{
+		{
+			AppID:   &backupApp,
+			Exclude: []Match{{Field: "title", Pattern: "(?i)completed successfully"}},
+		},
+		{
+			Include: []Match{
+				{Field: "message", Pattern: "."},
+				{Field: "extras.client::notification.bigImageUrl", Pattern: "^https://"},
+			},
+		},
+	}
+	require.NoError(t, cfg.IsValid())
+
+	tests := []struct {
+		name     string
+		msg      Message
+		filtered bool
+	}{
+		{
+			name:     "should drop excluded message",
+			msg:      Message{AppID: 2, Title: "Backup Completed Successfully", Message: "took 3m"},
+			filtered: true,
+		},
+		{name: "should send other message of app", msg: Message{AppID: 2, Title: "Backup failed", Message: "disk full"}},
+		{name: "should only exclude messages of app", msg: Message{AppID: 3, Title: "Backup completed successfully", Message: "took 3m"}},
+		{name: "should drop message not included", msg: Message{AppID: 3, Title: "empty"}, filtered: true},
+		{
+			name: "should include message by extras",
+			msg: Message{AppID: 3, Title: "image", Extras: map[string]interface{}{
+				"client::notification": map[string]interface{}{"bigImageUrl": "https://gotify.net/image.png"},
+			}},
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			require.Equal(t, tt.filtered, cfg.filtered(tt.msg))
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestFilterIsValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+	}{
+		{name: "should not accept no matches", filter: Filter{}},
+		{name: "should not accept invalid pattern", filter: Filter{Include: []Match{{Field: "title", Pattern: "(unclosed"}}}},
+		{name: "should not accept invalid field", filter: Filter{Exclude: []Match{{Field: "priority", Pattern: "1"}}}},
+		{name: "should not accept empty extras field", filter: Filter{Exclude: []Match{{Field: "extras.", Pattern: "1"}}}},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			require.Error(t, tt.filter.isValid())
+		}
+
+		t.Run(tt.name, test)
+	}
+}
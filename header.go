@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+)
+
+// reservedHeaders are the header fields set by the plugin, custom headers can
+// not override them
+var reservedHeaders = []string{
+	"From", "Sender", "To", "Cc", "Bcc", "Reply-To", "Subject", "Date", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding",
+	"List-Unsubscribe", "List-Unsubscribe-Post", "DKIM-Signature", "X-Gotify-App", "X-Gotify-App-Id",
+	"X-Gotify-Priority",
+}
+
+// ============================================================================
+
+// isValidHeaders is used to validate the Reply-To, List-Unsubscribe and custom
+// headers of the Smtp configuration, the custom headers are parsed once here
+func (s *Smtp) isValidHeaders() error {
+	v := &validation{}
+	headers := make(map[string]*template.Template, len(s.Headers))
+	v.emails("replyto", s.ReplyTo, false)
+
+	for i, uri := range s.ListUnsubscribe {
+		v.url(fmt.Sprintf("listunsubscribe[%d]", i), uri, "mailto", "https", "http")
+	}
+	if s.ListUnsubscribePost && !s.hasHTTPUnsubscribe() {
+		v.add("listunsubscribepost", errors.New("one click unsubscribing needs an http list unsubscribe url"))
+	}
+
+	for _, name := range sortedKeys(s.Headers) {
+		path := "headers." + name
+		if !isValidHeaderName(name) {
+			v.addf(path, "the header name %q is not valid", name)
+			continue
+		}
+		for _, reserved := range reservedHeaders {
+			if strings.EqualFold(name, reserved) {
+				v.addf(path, "the header %q is set by the plugin", name)
+			}
+		}
+		tmpl, err := template.New(name).Parse(s.Headers[name])
+		if err != nil {
+			v.addf(path, "the header is not a valid template: %w", err)
+			continue
+		}
+		headers[name] = tmpl
+	}
+	s.headerTemplates = headers
+
+	return v.err()
+}
+
+// isValidHeaderName is used to check that a header field name only contains
+// printable ASCII characters other than colon (RFC 5322)
+func isValidHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < 33 || name[i] > 126 || name[i] == ':' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasHTTPUnsubscribe is used to check for an http List-Unsubscribe url, one
+// click unsubscribing posts to it (RFC 8058)
+func (s *Smtp) hasHTTPUnsubscribe() bool {
+	for _, uri := range s.ListUnsubscribe {
+		if strings.HasPrefix(uri, "https:") || strings.HasPrefix(uri, "http:") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ============================================================================
+
+// headers is used to render the Gotify metadata and custom headers of a
+// message, the application name is empty when it is unknown. Custom headers
+// are templates of the message parsed by isValidHeaders, headers that can not
+// be rendered are skipped.
+func (s *Smtp) headers(msg Message, app string) []headerField {
+	header := []headerField{{"X-Gotify-App-Id", fmt.Sprintf("%d", msg.AppID)}}
+	if app != "" {
+		header = append(header, headerField{"X-Gotify-App", encodeHeader(app)})
+	}
+	header = append(header, headerField{"X-Gotify-Priority", fmt.Sprintf("%d", msg.Priority)})
+
+	for _, name := range sortedKeys(s.headerTemplates) {
+		var value bytes.Buffer
+		err := s.headerTemplates[name].Execute(&value, msg)
+		if err != nil {
+			log.Printf("SMTP Emailer: could not add header %q: %v\n", name, err)
+			continue
+		}
+		header = append(header, headerField{name, encodeHeader(value.String())})
+	}
+
+	return header
+}
+
+// listHeaders is used to build the Reply-To and List-Unsubscribe headers
+func (s *Smtp) listHeaders() ([]headerField, error) {
+	var header []headerField
+	if len(s.ReplyTo) > 0 {
+		replyTo, err := formatAddressList(s.ReplyTo)
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, headerField{"Reply-To", replyTo})
+	}
+
+	if len(s.ListUnsubscribe) > 0 {
+		uris := make([]string, 0, len(s.ListUnsubscribe))
+		for _, uri := range s.ListUnsubscribe {
+			uris = append(uris, "<"+uri+">")
+		}
+		header = append(header, headerField{"List-Unsubscribe", strings.Join(uris, ", ")})
+		if s.ListUnsubscribePost {
+			header = append(header, headerField{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"})
+		}
+	}
+
+	return header, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"net/mail"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmtpIsValidHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		smtp  Smtp
+		valid bool
+	}{
+		{name: "should accept no headers", smtp: Smtp{}, valid: true},
+		{
+			name: "should accept headers",
+			smtp: Smtp{
+				ReplyTo:             []string{"Helpdesk <help@email.com>"},
+				ListUnsubscribe:     []string{"mailto:unsubscribe@email.com", "https://email.com/unsubscribe"},
+				ListUnsubscribePost: true,
+				Headers:             map[string]string{"X-Team": "ops", "X-Title": "{{.Title}}"},
+			},
+			valid: true,
+		},
+		{name: "should not accept invalid reply to", smtp: Smtp{ReplyTo: []string{"help"}}},
+		{name: "should not accept invalid unsubscribe url", smtp: Smtp{ListUnsubscribe: []string{"ftp://email.com"}}},
+		{
+			name: "should not accept unsubscribe post without http url",
+			smtp: Smtp{ListUnsubscribe: []string{"mailto:unsubscribe@email.com"}, ListUnsubscribePost: true},
+		},
+		{name: "should not accept invalid header name", smtp: Smtp{Headers: map[string]string{"X Team": "ops"}}},
+		{name: "should not accept reserved header", smtp: Smtp{Headers: map[string]string{"subject": "ops"}}},
+		{name: "should not accept invalid template", smtp: Smtp{Headers: map[string]string{"X-Title": "{{.Title"}}},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			err := tt.smtp.isValidHeaders()
+			if tt.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	cfg := baseConfig
+	cfg.Smtp.ReplyTo = []string{"Helpdesk <help@email.com>"}
+	cfg.Smtp.ListUnsubscribe = []string{"mailto:unsubscribe@email.com", "https://email.com/unsubscribe"}
+	cfg.Smtp.ListUnsubscribePost = true
+	cfg.Smtp.Headers = map[string]string{
+		"X-Team":    "ops",
+		"X-Title":   "{{.Title}}",
+		"X-Missing": "{{.Missing}}",
+	}
+	require.NoError(t, cfg.Smtp.isValid())
+
+	email := cfg.render(Message{AppID: 4, Priority: 8, Title: "disk\nfull"}, &source{Name: "backup"})
+	messages, err := cfg.Smtp.build(email)
+	require.NoError(t, err)
+	msg, err := mail.ReadMessage(bytes.NewReader(messages[0].content))
+	require.NoError(t, err)
+
+	require.Equal(t, "4", msg.Header.Get("X-Gotify-App-Id"))
+	require.Equal(t, "backup", msg.Header.Get("X-Gotify-App"))
+	require.Equal(t, "8", msg.Header.Get("X-Gotify-Priority"))
+	t.Logf("\tP\tshould add gotify headers")
+
+	unknown := cfg.render(Message{AppID: 5, Title: "title"}, nil)
+	for _, field := range unknown.Headers {
+		require.NotEqual(t, "X-Gotify-App", field.Name)
+	}
+	t.Logf("\tP\tshould not add the application name when it is unknown")
+
+	require.Equal(t, "ops", msg.Header.Get("X-Team"))
+	require.Equal(t, "disk full", msg.Header.Get("X-Title"))
+	require.Empty(t, msg.Header.Get("X-Missing"))
+	t.Logf("\tP\tshould add custom headers")
+
+	require.Equal(t, "\"Helpdesk\" <help@email.com>", msg.Header.Get("Reply-To"))
+	require.Equal(t, "<mailto:unsubscribe@email.com>, <https://email.com/unsubscribe>", msg.Header.Get("List-Unsubscribe"))
+	require.Equal(t, "List-Unsubscribe=One-Click", msg.Header.Get("List-Unsubscribe-Post"))
+	t.Logf("\tP\tshould add reply to and list unsubscribe headers")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultImageMaxBytes       = 5 << 20
+	defaultImageTimeoutSeconds = 10
+
+	// maxImageRedirects is the number of redirects followed for an image
+	maxImageRedirects = 3
+)
+
+// publicAddress is used to check that images are only fetched from public
+// addresses, so message senders can not make the plugin reach services on
+// the Gotify host or its network
+var publicAddress = func(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+// Images represents the options for the notification big image
+type Images struct {
+	Disabled       bool   // Optional: only link to the image instead of embedding it
+	MaxBytes       *int64 // Optional: largest image that is embedded, defaults to 5 MiB
+	TimeoutSeconds *int   // Optional: time allowed for fetching the image, defaults to 10
+}
+
+// Attachment represents a file included in an email
+type Attachment struct {
+	ContentID   string // Optional: set for inline attachments
+	ContentType string
+	Filename    string
+	Data        []byte
+}
+
+// ============================================================================
+
+// isValid is used to validate the images configuration
+func (i *Images) isValid() error {
+	v := &validation{}
+	if i.MaxBytes != nil && *i.MaxBytes <= 0 {
+		v.addf("maxbytes", "the max bytes %d is not positive", *i.MaxBytes)
+	}
+	if i.TimeoutSeconds != nil && *i.TimeoutSeconds <= 0 {
+		v.addf("timeoutseconds", "the timeout %d is not positive", *i.TimeoutSeconds)
+	}
+
+	return v.err()
+}
+
+// ============================================================================
+
+// maxBytes is used to get the largest image embedded
+func (i *Images) maxBytes() int64 {
+	if i.MaxBytes != nil {
+		return *i.MaxBytes
+	}
+
+	return defaultImageMaxBytes
+}
+
+// fetch is used to download an image so it can be embedded in an email, only
+// http and https images on public addresses are fetched
+func (i *Images) fetch(uri string) (*Attachment, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse image url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported image url scheme %q", u.Scheme)
+	}
+
+	maxBytes := i.maxBytes()
+	timeout := defaultImageTimeoutSeconds
+	if i.TimeoutSeconds != nil {
+		timeout = *i.TimeoutSeconds
+	}
+
+	// addresses are checked when dialing so redirects and hostnames resolving
+	// to private addresses are refused as well
+	dialer := &net.Dialer{
+		Timeout: time.Duration(timeout) * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !publicAddress(ip) {
+				return fmt.Errorf("image address %s is not public", host)
+			}
+			return nil
+		},
+	}
+	client := http.Client{
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxImageRedirects {
+				return fmt.Errorf("image redirected more than %d times", maxImageRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("unsupported image url scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	defer client.CloseIdleConnections()
+	res, err := client.Get(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("could not get image: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invalid image response: %d", res.StatusCode)
+	}
+	if res.ContentLength > maxBytes {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not read image: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxBytes)
+	}
+
+	return newImage(data, u.Path)
+}
+
+// newImage is used to make an inline attachment of image data, the filename
+// is taken from the path
+func newImage(data []byte, p string) (*Attachment, error) {
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("unsupported image content type %q", contentType)
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	filename := path.Base(p)
+	if filename == "." || filename == "/" {
+		filename = "image"
+	}
+
+	return &Attachment{
+		ContentID:   id + "@gotify-smtp-emailer",
+		ContentType: contentType,
+		Filename:    filename,
+		Data:        data,
+	}, nil
+}
+
+// ============================================================================
+
+// randomID is used to generate a random hex identifier
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("could not generate id: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderImage(t *testing.T) {
+	var img bytes.Buffer
+	src := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	src.Set(1, 1, color.White)
+	err := png.Encode(&img, src)
+	require.NoError(t, err)
+
+	// the test server runs on a loopback address
+	defer func(check func(net.IP) bool) { publicAddress = check }(publicAddress)
+	publicAddress = func(net.IP) bool { return true }
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/snapshot.png":
+			w.Write(img.Bytes())
+		case "/page.html":
+			w.Write([]byte("<html><body>not an image</body></html>"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		images   Images
+		embedded bool
+	}{
+		{
+			name:     "should embed image",
+			url:      srv.URL + "/snapshot.png",
+			embedded: true,
+		},
+		{
+			name:     "should link image larger than max bytes",
+			url:      srv.URL + "/snapshot.png",
+			images:   Images{MaxBytes: toPtr(int64(10))},
+			embedded: false,
+		},
+		{
+			name:     "should link image when embedding is disabled",
+			url:      srv.URL + "/snapshot.png",
+			images:   Images{Disabled: true},
+			embedded: false,
+		},
+		{
+			name:     "should link missing image",
+			url:      srv.URL + "/missing.png",
+			embedded: false,
+		},
+		{
+			name:     "should link content that is not an image",
+			url:      srv.URL + "/page.html",
+			embedded: false,
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			cfg := baseConfig
+			cfg.Images = tt.images
+
+			email := cfg.render(Message{
+				Title:   "title",
+				Message: "message",
+				Extras: map[string]interface{}{
+					"client::notification": map[string]interface{}{
+						"bigImageUrl": tt.url,
+					},
+				},
+			}, nil)
+
+			if tt.embedded {
+				require.Len(t, email.Inline, 1)
+				require.Equal(t, "image/png", email.Inline[0].ContentType)
+				require.Equal(t, img.Bytes(), email.Inline[0].Data)
+				require.Contains(t, email.HTML, "cid:"+email.Inline[0].ContentID)
+			} else {
+				require.Empty(t, email.Inline)
+				require.Contains(t, email.HTML, "href=\""+tt.url+"\"")
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestImageFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/file":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	images := Images{}
+	_, err := images.fetch(srv.URL + "/snapshot.png")
+	require.ErrorContains(t, err, "is not public")
+	_, err = images.fetch("http://[::1]/snapshot.png")
+	require.ErrorContains(t, err, "is not public")
+	_, err = images.fetch("http://169.254.169.254/latest/meta-data")
+	require.ErrorContains(t, err, "is not public")
+	t.Logf("\tP\tshould not fetch images from private addresses")
+
+	_, err = images.fetch("ftp://example.com/snapshot.png")
+	require.ErrorContains(t, err, "unsupported image url scheme")
+	t.Logf("\tP\tshould only fetch http and https images")
+
+	defer func(check func(net.IP) bool) { publicAddress = check }(publicAddress)
+	publicAddress = func(net.IP) bool { return true }
+	_, err = images.fetch(srv.URL + "/loop")
+	require.ErrorContains(t, err, "redirected more than")
+	_, err = images.fetch(srv.URL + "/file")
+	require.ErrorContains(t, err, "unsupported image url scheme")
+	t.Logf("\tP\tshould limit redirects")
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDirMaxBytes  = 100 << 20
+	defaultDirMaxFiles  = 1000
+	defaultMboxMaxBytes = 10 << 20
+	defaultMboxMaxFiles = 5
+)
+
+// maildir is used to deliver emails into a Maildir
+type maildir struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+}
+
+// mbox is used to append emails to an mbox file
+type mbox struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+}
+
+// emlDir is used to write each email into its own .eml file
+type emlDir struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+}
+
+// ============================================================================
+
+// newMaildir is used to create a Maildir transport
+func newMaildir(o Output) *maildir {
+	maxBytes, maxFiles := o.limits(defaultDirMaxBytes, defaultDirMaxFiles)
+	return &maildir{path: o.Path, maxBytes: maxBytes, maxFiles: maxFiles}
+}
+
+// newMbox is used to create an mbox transport
+func newMbox(o Output) *mbox {
+	maxBytes, maxFiles := o.limits(defaultMboxMaxBytes, defaultMboxMaxFiles)
+	return &mbox{path: o.Path, maxBytes: maxBytes, maxFiles: maxFiles}
+}
+
+// newEmlDir is used to create an .eml directory transport
+func newEmlDir(o Output) *emlDir {
+	maxBytes, maxFiles := o.limits(defaultDirMaxBytes, defaultDirMaxFiles)
+	return &emlDir{path: o.Path, maxBytes: maxBytes, maxFiles: maxFiles}
+}
+
+// limits is used to get the output limits or the given defaults
+func (o *Output) limits(maxBytes int64, maxFiles int) (int64, int) {
+	if o.MaxBytes != nil {
+		maxBytes = *o.MaxBytes
+	}
+	if o.MaxFiles != nil {
+		maxFiles = *o.MaxFiles
+	}
+
+	return maxBytes, maxFiles
+}
+
+// ============================================================================
+
+// Deliver implements Transport
+// The email is written into tmp and then moved into new, the oldest
+// messages are removed when the Maildir grows past its limits.
+func (m *maildir) Deliver(from string, to []string, content []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(m.path, dir), 0o700)
+		if err != nil {
+			return fmt.Errorf("could not create maildir: %w", err)
+		}
+	}
+
+	err := pruneDirs(m.maxFiles, m.maxBytes, int64(len(content)),
+		filepath.Join(m.path, "new"), filepath.Join(m.path, "cur"))
+	if err != nil {
+		return err
+	}
+
+	name, err := maildirName()
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(m.path, "tmp", name)
+	err = writeFileSync(tmp, content)
+	if err != nil {
+		return fmt.Errorf("could not write maildir message: %w", err)
+	}
+	err = os.Rename(tmp, filepath.Join(m.path, "new", name))
+	if err != nil {
+		return fmt.Errorf("could not move maildir message: %w", err)
+	}
+
+	return nil
+}
+
+// maildirName is used to generate a unique Maildir file name
+func maildirName() (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", "\\057", ":", "\\072").Replace(host)
+
+	now := time.Now()
+	return fmt.Sprintf("%d.M%dP%dR%s.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), id[:8], host), nil
+}
+
+// ============================================================================
+
+// Deliver implements Transport
+// The mbox file is rotated when the email would grow it past its limit.
+func (m *mbox) Deliver(from string, to []string, content []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := mboxEntry(from, time.Now(), content)
+
+	fi, err := os.Stat(m.path)
+	if err == nil && fi.Size() > 0 && fi.Size()+int64(len(entry)) > m.maxBytes {
+		err = rotateFile(m.path, m.maxFiles)
+		if err != nil {
+			return fmt.Errorf("could not rotate mbox: %w", err)
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(m.path), 0o700)
+	if err != nil {
+		return fmt.Errorf("could not create mbox directory: %w", err)
+	}
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open mbox: %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(entry)
+	if err != nil {
+		return fmt.Errorf("could not write mbox: %w", err)
+	}
+
+	return f.Close()
+}
+
+// mboxEntry is used to format an email as an mboxrd entry, lines starting
+// with "From " are quoted so they do not start a new message
+func mboxEntry(from string, date time.Time, content []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(fmt.Sprintf("From %s %s\n", from, date.UTC().Format(time.ANSIC)))
+
+	content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	content = bytes.TrimSuffix(content, []byte("\n"))
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			b.WriteString(">")
+		}
+		b.Write(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	return b.Bytes()
+}
+
+// rotateFile is used to move a file to path.1, keeping up to keep rotated
+// files
+func rotateFile(path string, keep int) error {
+	err := os.Remove(fmt.Sprintf("%s.%d", path, keep))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := keep - 1; i >= 1; i-- {
+		err = os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(path, path+".1")
+}
+
+// ============================================================================
+
+// Deliver implements Transport
+// The oldest files are removed when the directory grows past its limits.
+func (e *emlDir) Deliver(from string, to []string, content []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	err := os.MkdirAll(e.path, 0o700)
+	if err != nil {
+		return fmt.Errorf("could not create eml directory: %w", err)
+	}
+
+	err = pruneDirs(e.maxFiles, e.maxBytes, int64(len(content)), e.path)
+	if err != nil {
+		return err
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), id[:8])
+	err = writeFileSync(filepath.Join(e.path, name), content)
+	if err != nil {
+		return fmt.Errorf("could not write eml file: %w", err)
+	}
+
+	return nil
+}
+
+// ============================================================================
+
+// pruneDirs is used to remove the oldest files of the given directories until
+// another file of size bytes fits within the limits
+func pruneDirs(maxFiles int, maxBytes int64, size int64, dirs ...string) error {
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	var files []file
+	var total int64
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("could not read directory: %w", err)
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			files = append(files, file{filepath.Join(dir, entry.Name()), info.Size(), info.ModTime()})
+			total += info.Size()
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].modTime.Equal(files[j].modTime) {
+			return filepath.Base(files[i].path) < filepath.Base(files[j].path)
+		}
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	for len(files) > 0 && (len(files)+1 > maxFiles || total+size > maxBytes) {
+		err := os.Remove(files[0].path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove old file: %w", err)
+		}
+		total -= files[0].size
+		files = files[1:]
+	}
+
+	return nil
+}
+
+// writeFileSync is used to write a file and flush it to disk
+func writeFileSync(path string, content []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(content)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
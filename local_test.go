@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalTransports(t *testing.T) {
+	content := []byte("From: from@email.com\r\nSubject: test\r\n\r\nFrom here on\r\ntest message\r\n")
+
+	t.Run("should deliver into maildir", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "Maildir")
+		cfg := baseConfig
+		cfg.Output = Output{Type: outputMaildir, Path: dir, MaxFiles: toPtr(2)}
+		require.NoError(t, cfg.IsValid())
+
+		transport := newTransport(&cfg)
+		for i := 0; i < 3; i++ {
+			err := transport.Deliver("from@email.com", []string{"to@email.com"}, content)
+			require.NoError(t, err)
+		}
+
+		entries, err := os.ReadDir(filepath.Join(dir, "new"))
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		b, err := os.ReadFile(filepath.Join(dir, "new", entries[0].Name()))
+		require.NoError(t, err)
+		require.Equal(t, content, b)
+
+		entries, err = os.ReadDir(filepath.Join(dir, "tmp"))
+		require.NoError(t, err)
+		require.Empty(t, entries)
+	})
+
+	t.Run("should append to and rotate mbox", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "gotify.mbox")
+		cfg := baseConfig
+		cfg.Output = Output{Type: outputMbox, Path: path, MaxBytes: toPtr(int64(200)), MaxFiles: toPtr(1)}
+		require.NoError(t, cfg.IsValid())
+
+		transport := newTransport(&cfg)
+		for i := 0; i < 3; i++ {
+			err := transport.Deliver("from@email.com", []string{"to@email.com"}, content)
+			require.NoError(t, err)
+		}
+
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(b), "From from@email.com "))
+		require.Contains(t, string(b), "\n>From here on\n")
+		require.NotContains(t, string(b), "\r\n")
+		require.Equal(t, 1, strings.Count(string(b), "\nSubject: test\n"))
+
+		_, err = os.Stat(path + ".1")
+		require.NoError(t, err)
+		_, err = os.Stat(path + ".2")
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("should write eml files", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "eml")
+		cfg := baseConfig
+		cfg.Output = Output{Type: outputEml, Path: dir, MaxBytes: toPtr(int64(len(content) * 2))}
+		require.NoError(t, cfg.IsValid())
+
+		transport := newTransport(&cfg)
+		for i := 0; i < 3; i++ {
+			err := transport.Deliver("from@email.com", []string{"to@email.com"}, content)
+			require.NoError(t, err)
+		}
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.True(t, strings.HasSuffix(entries[0].Name(), ".eml"))
+	})
+
+	t.Run("should not create output without path", func(t *testing.T) {
+		cfg := baseConfig
+		cfg.Output = Output{Type: outputEml}
+		require.Error(t, cfg.IsValid())
+	})
+}
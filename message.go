@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message represents a message received from the Gotify stream
+type Message struct {
+	ID       uint
+	AppID    uint
+	Title    string
+	Message  string
+	Priority int
+	Extras   map[string]interface{}
+	Date     time.Time
+}
+
+// ============================================================================
+
+// extra is used to get a nested extras value, for example
+// extra("client::notification", "bigImageUrl")
+func (m *Message) extra(keys ...string) (interface{}, bool) {
+	var value interface{} = m.Extras
+	for _, key := range keys {
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = values[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// extraString is used to get a nested extras value as a string, an empty
+// string is returned when the value does not exist or is not a string
+func (m *Message) extraString(keys ...string) string {
+	value, ok := m.extra(keys...)
+	if !ok {
+		return ""
+	}
+	s, _ := value.(string)
+	return s
+}
+
+// field is used to get a message field by name: title, message or an extras
+// value like extras.client::notification.click.url. Extras values that are not
+// strings are formatted.
+func (m *Message) field(name string) (string, bool) {
+	switch name {
+	case "title":
+		return m.Title, true
+	case "message":
+		return m.Message, true
+	}
+
+	path, ok := strings.CutPrefix(name, "extras.")
+	if !ok {
+		return "", false
+	}
+	value, ok := m.extra(strings.Split(path, ".")...)
+	if !ok {
+		return "", false
+	}
+	if s, ok := value.(string); ok {
+		return s, true
+	}
+
+	return fmt.Sprint(value), true
+}
+
+// isValidField is used to check a message field name
+func isValidField(name string) bool {
+	return name == "title" || name == "message" ||
+		(strings.HasPrefix(name, "extras.") && len(name) > len("extras."))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gotify/plugin-api"
+)
+
+// migration is used to upgrade a config from the previous version, it returns
+// what was changed
+type migration func(c *Config) []string
+
+// migrations upgrade configs one version at a time, migrations[i] upgrades a
+// config of version i to version i+1
+var migrations = []migration{
+	migrateInsecure,
+	migrateEnvironment,
+}
+
+// currentConfigVersion is the version of configs created by this release
+var currentConfigVersion = len(migrations)
+
+// migrationState represents the last migration the admin was notified of
+type migrationState struct {
+	From int
+	To   int
+}
+
+// ============================================================================
+
+// migrate is used to upgrade an older config into the current shape, it
+// returns what was changed
+func (c *Config) migrate() ([]string, error) {
+	if c.Version < 0 || c.Version > currentConfigVersion {
+		return nil, fmt.Errorf("the config version %d is not supported, the latest is %d", c.Version, currentConfigVersion)
+	}
+
+	var changes []string
+	for c.Version < currentConfigVersion {
+		changes = append(changes, migrations[c.Version](c)...)
+		c.Version++
+	}
+
+	return changes, nil
+}
+
+// migrationNotice is used to describe a migration to the admin
+func migrationNotice(from, to int, changes []string) string {
+	var notice strings.Builder
+	notice.WriteString(fmt.Sprintf("The config was migrated from version %d to %d.", from, to))
+	for _, change := range changes {
+		notice.WriteString("\n- " + change)
+	}
+	notice.WriteString("\n\nUpdate the saved config the same way, this notice is only sent once.")
+
+	return notice.String()
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+// Gotify decodes the saved config onto the default config, which has the
+// current version, configs saved without a version predate versioning
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Config
+	c.Version = 0
+
+	return unmarshal((*plain)(c))
+}
+
+// notifyMigration is used to tell the admin about a migration, Gotify loads
+// the saved config on every start so each migration is only notified once
+func (c *Plugin) notifyMigration(from, to int, changes []string) {
+	if c.msgHandler == nil {
+		return
+	}
+
+	notified := false
+	err := c.updateStorage(func(data *storageData) {
+		migration := migrationState{From: from, To: to}
+		if data.Migration != nil && *data.Migration == migration {
+			notified = true
+			return
+		}
+		data.Migration = &migration
+	})
+	if err != nil {
+		log.Printf("SMTP Emailer: could not store migration notice: %v\n", err)
+	}
+	if notified {
+		return
+	}
+
+	c.msgHandler.SendMessage(plugin.Message{
+		Title:   "SMTP Emailer: Config migrated",
+		Message: migrationNotice(from, to, changes),
+	})
+}
+
+// ============================================================================
+
+// migrateInsecure is used to replace insecure of the smtp server and relays
+// with tls mode none, version 0 had no tls mode so any set is a default.
+// Secure servers used STARTTLS only when it was offered, they now require it.
+func migrateInsecure(c *Config) []string {
+	var changes []string
+	migrate := func(s *Smtp, name string) {
+		if !s.Insecure {
+			s.TLSMode = tlsStartTLS
+			changes = append(changes, fmt.Sprintf("%s now requires STARTTLS instead of sending unencrypted "+
+				"when the server does not offer it, set tlsmode: none for such servers", name))
+			return
+		}
+		s.TLSMode = tlsNone
+		s.Insecure = false
+		changes = append(changes, fmt.Sprintf("%s insecure: true was replaced by tlsmode: none", name))
+	}
+
+	migrate(&c.Smtp, "smtp")
+	for i := range c.Relays {
+		migrate(&c.Relays[i], fmt.Sprintf("relay %d", i))
+	}
+
+	return changes
+}
+
+// migrateEnvironment is used to replace the environment with diagnostics,
+// development enabled every diagnostic and production none
+func migrateEnvironment(c *Config) []string {
+	environment := c.Environment
+	c.Environment = ""
+	if environment != "development" {
+		return nil
+	}
+
+	c.Diagnostics = Diagnostics{
+		LogLevel:     logDebug,
+		Notices:      true,
+		Heartbeat:    &Heartbeat{IntervalSeconds: 10, Email: true},
+		ReportErrors: reportAll,
+	}
+
+	return []string{"environment: development was replaced by diagnostics with debug logging, notices, " +
+		"an emailed heartbeat every 10 seconds and all errors reported"}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gotify/plugin-api"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// memoryMessages implements plugin.MessageHandler
+// It is used to keep the messages sent by the plugin
+type memoryMessages struct {
+	sent []plugin.Message
+}
+
+func (m *memoryMessages) SendMessage(msg plugin.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestConfigMigrate(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       func() Config
+		tlsMode      string
+		relayTLSMode string
+		changes      int
+		diagnostics  bool
+		pass         bool
+	}{
+		{
+			name: "should replace insecure with tls mode none",
+			config: func() Config {
+				cfg := baseConfig
+				cfg.Smtp.TLSMode = tlsStartTLS
+				cfg.Smtp.Insecure = true
+				cfg.Relays = []Smtp{{Host: "relay.host.com", Port: 25, Insecure: true}}
+				return cfg
+			},
+			tlsMode:      tlsNone,
+			relayTLSMode: tlsNone,
+			changes:      2,
+			pass:         true,
+		},
+		{
+			name: "should require starttls of secure config",
+			config: func() Config {
+				cfg := baseConfig
+				cfg.Smtp.TLSMode = ""
+				cfg.Relays = []Smtp{{Host: "relay.host.com", Port: 25}}
+				return cfg
+			},
+			tlsMode:      tlsStartTLS,
+			relayTLSMode: tlsStartTLS,
+			changes:      2,
+			pass:         true,
+		},
+		{
+			name: "should replace development environment with diagnostics",
+			config: func() Config {
+				cfg := baseConfig
+				cfg.Environment = "development"
+				return cfg
+			},
+			tlsMode:     tlsStartTLS,
+			changes:     2,
+			diagnostics: true,
+			pass:        true,
+		},
+		{
+			name: "should drop production environment",
+			config: func() Config {
+				cfg := baseConfig
+				cfg.Version = 1
+				cfg.Environment = "production"
+				return cfg
+			},
+			tlsMode: tlsStartTLS,
+			pass:    true,
+		},
+		{
+			name: "should not migrate current config",
+			config: func() Config {
+				cfg := baseConfig
+				cfg.Version = currentConfigVersion
+				return cfg
+			},
+			tlsMode: tlsStartTLS,
+			pass:    true,
+		},
+		{
+			name: "should not accept newer config",
+			config: func() Config {
+				cfg := baseConfig
+				cfg.Version = currentConfigVersion + 1
+				return cfg
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			cfg := tt.config()
+			cfg.Relays = append([]Smtp{}, cfg.Relays...)
+			changes, err := cfg.migrate()
+			if !tt.pass {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, changes, tt.changes)
+			require.Equal(t, currentConfigVersion, cfg.Version)
+			require.Equal(t, tt.tlsMode, cfg.Smtp.TLSMode)
+			require.False(t, cfg.Smtp.Insecure)
+			require.Empty(t, cfg.Environment)
+			if tt.diagnostics {
+				require.True(t, cfg.Diagnostics.debug())
+				require.True(t, cfg.Diagnostics.Notices)
+				require.Equal(t, 10, cfg.Diagnostics.Heartbeat.IntervalSeconds)
+			}
+			for _, relay := range cfg.Relays {
+				require.Equal(t, tt.relayTLSMode, relay.TLSMode)
+				require.False(t, relay.Insecure)
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestValidateAndSetConfigMigrates(t *testing.T) {
+	messages := &memoryMessages{}
+	p := &Plugin{}
+	p.SetMessageHandler(messages)
+	p.SetStorageHandler(&memoryStorage{})
+
+	cfg := baseConfig
+	cfg.Smtp.Insecure = true
+	require.NoError(t, p.ValidateAndSetConfig(&cfg))
+	require.Equal(t, tlsNone, p.config.Smtp.TLSMode)
+	require.Len(t, messages.sent, 1)
+	require.Contains(t, messages.sent[0].Message, fmt.Sprintf("from version 0 to %d", currentConfigVersion))
+	require.Contains(t, messages.sent[0].Message, "smtp insecure: true was replaced by tlsmode: none")
+	t.Logf("\tP\tshould notify the admin of the migration")
+
+	cfg = baseConfig
+	cfg.Smtp.Insecure = true
+	require.NoError(t, p.ValidateAndSetConfig(&cfg))
+	require.Len(t, messages.sent, 1)
+	t.Logf("\tP\tshould notify the admin of a migration once")
+
+	cfg = baseConfig
+	cfg.Version = 1
+	require.NoError(t, p.ValidateAndSetConfig(&cfg))
+	require.Len(t, messages.sent, 1)
+	t.Logf("\tP\tshould not notify when nothing was migrated")
+
+	cfg = baseConfig
+	cfg.Version = currentConfigVersion
+	cfg.Smtp.Insecure = true
+	require.Error(t, p.ValidateAndSetConfig(&cfg))
+	t.Logf("\tP\tshould not accept insecure in current configs")
+
+	cfg = baseConfig
+	cfg.Version = currentConfigVersion
+	cfg.Environment = "production"
+	require.Error(t, p.ValidateAndSetConfig(&cfg))
+	t.Logf("\tP\tshould not accept environment in current configs")
+}
+
+func TestConfigUnmarshalVersion(t *testing.T) {
+	p := &Plugin{}
+	require.Equal(t, currentConfigVersion, p.DefaultConfig().(*Config).Version)
+	t.Logf("\tP\tshould create configs of the current version")
+
+	cfg := p.DefaultConfig().(*Config)
+	require.NoError(t, yaml.Unmarshal([]byte("hostname: ws://gotify\n"), cfg))
+	require.Equal(t, 0, cfg.Version)
+	require.Equal(t, "ws://gotify", cfg.Hostname)
+	require.Equal(t, "smtp.example.com", cfg.Smtp.Host)
+	t.Logf("\tP\tshould load configs saved without a version as version 0")
+
+	cfg = p.DefaultConfig().(*Config)
+	require.NoError(t, yaml.Unmarshal([]byte("version: 1\n"), cfg))
+	require.Equal(t, 1, cfg.Version)
+	t.Logf("\tP\tshould load the saved version")
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+const (
+	pgpMissingKeyPlain = "plain"
+	pgpMissingKeySkip  = "skip"
+)
+
+// PGP represents the options for encrypting emails with OpenPGP
+type PGP struct {
+	Keys              map[string]string // Armored public key of each recipient email, may be a file: or env: reference
+	SigningKey        *string           // Optional: armored private key used to sign emails, may be a file: or env: reference
+	SigningPassphrase *string           // Optional: passphrase of the signing key, may be a file: or env: reference
+	MissingKey        string            // Optional: plain or skip, what recipients without a key get, defaults to plain
+
+	keys    map[string]*openpgp.Entity // parsed public keys by lowercased bare address, set by isValid
+	signing *openpgp.Entity            // parsed and unlocked signing key, set by isValid
+}
+
+// ============================================================================
+
+// isValid is used to validate the PGP configuration, the keys are parsed and
+// unlocked once here so emails are encrypted without parsing them again
+func (p *PGP) isValid() error {
+	v := &validation{}
+	if p.MissingKey != "" && p.MissingKey != pgpMissingKeyPlain && p.MissingKey != pgpMissingKeySkip {
+		v.addf("missingkey", "the missing key policy %q is not valid", p.MissingKey)
+	}
+	keys := make(map[string]*openpgp.Entity, len(p.Keys))
+	for _, email := range sortedKeys(p.Keys) {
+		path := "keys." + email
+		v.email(path, email)
+		key, err := parsePublicKey(p.Keys[email])
+		if err != nil {
+			v.addf(path, "the key is not valid: %w", err)
+			continue
+		}
+		keys[strings.ToLower(envelopeAddress(email))] = key
+	}
+	signing, err := p.parseSigner()
+	if err != nil {
+		v.addf("signingkey", "the signing key is not valid: %w", err)
+	}
+	p.keys = keys
+	p.signing = signing
+
+	return v.err()
+}
+
+// ============================================================================
+
+// parsePublicKey is used to parse the armored public key of a recipient
+func parsePublicKey(key string) (*openpgp.Entity, error) {
+	armored, err := resolveSecret(key)
+	if err != nil {
+		return nil, err
+	}
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) == 0 {
+		return nil, errors.New("no key found")
+	}
+
+	return entities[0], nil
+}
+
+// parseSigner is used to parse and unlock the signing key, nil is returned
+// when emails are not signed
+func (p *PGP) parseSigner() (*openpgp.Entity, error) {
+	if p.SigningKey == nil {
+		return nil, nil
+	}
+
+	armored, err := resolveSecret(*p.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) == 0 || entities[0].PrivateKey == nil {
+		return nil, errors.New("no private key found")
+	}
+	entity := entities[0]
+
+	if entity.PrivateKey.Encrypted {
+		if p.SigningPassphrase == nil {
+			return nil, errors.New("the signing key needs a passphrase")
+		}
+		passphrase, err := resolveSecret(*p.SigningPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		err = entity.PrivateKey.Decrypt([]byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("could not unlock signing key: %w", err)
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				err = subkey.PrivateKey.Decrypt([]byte(passphrase))
+				if err != nil {
+					return nil, fmt.Errorf("could not unlock signing subkey: %w", err)
+				}
+			}
+		}
+	}
+
+	return entity, nil
+}
+
+// ============================================================================
+
+// encrypt is used to encrypt a MIME entity as PGP/MIME for the recipients
+// with a key. Depending on the missing key policy recipients without a key
+// get the entity as is or nothing.
+func (p *PGP) encrypt(to []string, entity []byte) ([]outgoing, error) {
+	var keyed, plain []string
+	var keys openpgp.EntityList
+	for _, email := range to {
+		key := p.keys[strings.ToLower(email)]
+		if key == nil {
+			plain = append(plain, email)
+			continue
+		}
+		keyed = append(keyed, email)
+		keys = append(keys, key)
+	}
+
+	var messages []outgoing
+	if len(keyed) > 0 {
+		encrypted, err := p.encryptEntity(keys, entity)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, outgoing{to: keyed, content: encrypted})
+	}
+	if len(plain) > 0 {
+		if p.MissingKey == pgpMissingKeySkip {
+			log.Printf("SMTP Emailer: not sending to recipients without a pgp key: %s\n", strings.Join(plain, ", "))
+		} else {
+			messages = append(messages, outgoing{to: plain, content: entity})
+		}
+	}
+
+	return messages, nil
+}
+
+// encryptEntity is used to build a multipart/encrypted entity (RFC 3156)
+// containing the encrypted and optionally signed entity
+func (p *PGP) encryptEntity(keys openpgp.EntityList, entity []byte) ([]byte, error) {
+	var armored bytes.Buffer
+	aw, err := armor.Encode(&armored, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not armor pgp message: %w", err)
+	}
+	pw, err := openpgp.Encrypt(aw, keys, p.signing, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt pgp message: %w", err)
+	}
+	_, err = pw.Write(entity)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt pgp message: %w", err)
+	}
+	err = pw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt pgp message: %w", err)
+	}
+	err = aw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not armor pgp message: %w", err)
+	}
+
+	// armored lines end with LF, entities are sent with CRLF line endings
+	encrypted := bytes.ReplaceAll(armored.Bytes(), []byte("\n"), []byte("\r\n"))
+
+	body, err := multipartPart("encrypted", map[string]string{"protocol": "application/pgp-encrypted"},
+		mimePart{
+			header: []headerField{
+				{"Content-Type", "application/pgp-encrypted"},
+				{"Content-Description", "PGP/MIME version identification"},
+			},
+			body: []byte("Version: 1\r\n"),
+		},
+		mimePart{
+			header: []headerField{
+				{"Content-Type", "application/octet-stream; name=\"encrypted.asc\""},
+				{"Content-Description", "OpenPGP encrypted message"},
+				{"Content-Disposition", "inline; filename=\"encrypted.asc\""},
+			},
+			body: append(encrypted, "\r\n"...),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return body.bytes(), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/stretchr/testify/require"
+)
+
+func armoredKey(t *testing.T, entity *openpgp.Entity, private bool) string {
+	var b bytes.Buffer
+	blockType := openpgp.PublicKeyType
+	if private {
+		blockType = openpgp.PrivateKeyType
+	}
+	w, err := armor.Encode(&b, blockType, nil)
+	require.NoError(t, err)
+	if private {
+		err = entity.SerializePrivate(w, nil)
+	} else {
+		err = entity.Serialize(w)
+	}
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return b.String()
+}
+
+func TestPGPEncrypt(t *testing.T) {
+	recipient, err := openpgp.NewEntity("Recipient", "", "to@email.com", nil)
+	require.NoError(t, err)
+	sender, err := openpgp.NewEntity("Sender", "", "from@email.com", nil)
+	require.NoError(t, err)
+
+	s := baseConfig.Smtp
+	s.ToEmails = []string{"Recipient <to@email.com>", "other@email.com"}
+	s.PGP = &PGP{
+		Keys:       map[string]string{"To@Email.com": armoredKey(t, recipient, false)},
+		SigningKey: toPtr(armoredKey(t, sender, true)),
+	}
+	require.NoError(t, s.isValid())
+
+	messages, err := s.build(&Email{Subject: "test title", HTML: "<p>test message</p>", Text: "test message"})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	t.Logf("\tP\tshould split recipients with and without key")
+
+	require.Equal(t, []string{"to@email.com"}, messages[0].to)
+	msg, err := mail.ReadMessage(bytes.NewReader(messages[0].content))
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/encrypted", mediaType)
+	require.Equal(t, "application/pgp-encrypted", params["protocol"])
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	part, err := r.NextPart()
+	require.NoError(t, err)
+	version, err := io.ReadAll(part)
+	require.NoError(t, err)
+	require.Equal(t, "Version: 1\r\n", string(version))
+	part, err = r.NextPart()
+	require.NoError(t, err)
+
+	block, err := armor.Decode(part)
+	require.NoError(t, err)
+	keyring := openpgp.EntityList{recipient, sender}
+	md, err := openpgp.ReadMessage(block.Body, keyring, nil, nil)
+	require.NoError(t, err)
+	decrypted, err := io.ReadAll(md.UnverifiedBody)
+	require.NoError(t, err)
+	require.NoError(t, md.SignatureError)
+	require.True(t, md.IsSigned)
+	require.Equal(t, sender.PrimaryKey.KeyId, md.SignedByKeyId)
+	require.True(t, strings.HasPrefix(string(decrypted), "Content-Type: multipart/alternative"))
+	require.Contains(t, string(decrypted), "test message")
+	t.Logf("\tP\tshould encrypt and sign for recipient with key")
+
+	require.Equal(t, []string{"other@email.com"}, messages[1].to)
+	require.Contains(t, string(messages[1].content), "<p>test message</p>")
+	t.Logf("\tP\tshould send plain copy to recipient without key")
+
+	s.PGP.MissingKey = pgpMissingKeySkip
+	messages, err = s.build(&Email{Subject: "test title", HTML: "<p>test message</p>"})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, []string{"to@email.com"}, messages[0].to)
+	t.Logf("\tP\tshould skip recipient without key")
+}
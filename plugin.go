@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -26,13 +29,25 @@ func GetGotifyPluginInfo() plugin.Info {
 
 // Plugin is the gotify plugin instance.
 type Plugin struct {
-	userCtx    plugin.UserContext
-	msgHandler plugin.MessageHandler
-	config     *Config
-	enabled    bool
-	connection *websocket.Conn
-	done       chan bool
-	err        error
+	userCtx        plugin.UserContext
+	msgHandler     plugin.MessageHandler
+	storageHandler plugin.StorageHandler
+	storageMu      sync.Mutex
+	config         *Config
+	configLoaded   bool // set by the first ValidateAndSetConfig, later configs are saved by the user
+	transport      Transport
+	transports     map[string]Transport
+	recorder       *recorder
+	basePath       string
+	apps           map[uint]application
+	icons          map[string]*Attachment // Application icons by image path, nil when unavailable
+	appsMu         sync.Mutex
+	appsFetched    time.Time
+	enabled        bool
+	connection     *websocket.Conn
+	done           chan bool // closed to stop the goroutines started by handleWS
+	stopped        chan bool // closed when handleMessages has returned
+	err            error
 }
 
 // ============================================================================
@@ -40,24 +55,14 @@ type Plugin struct {
 // Enable is called when the plugin is enabled
 func (c *Plugin) Enable() error {
 	if c.config == nil {
-		if c.msgHandler != nil {
-			c.msgHandler.SendMessage(plugin.Message{
-				Title:   "SMTP Emailer: Error",
-				Message: fmt.Sprintf("no config set"),
-			})
-		}
+		c.reportError("no config set", false)
 		return fmt.Errorf("no config set")
 	}
 
 	err := c.config.IsValid()
 	if err != nil {
-		if c.msgHandler != nil {
-			c.msgHandler.SendMessage(plugin.Message{
-				Title:   "SMTP Emailer: Error",
-				Message: fmt.Sprintf("config is not valid: %v", err),
-			})
-		}
-		return fmt.Errorf("config is invalid: %w", err)
+		c.reportError(fmt.Sprintf("config is not valid: %v", err), false)
+		return fmt.Errorf("config is invalid:\n%w", err)
 	}
 
 	// Start websocket connection
@@ -74,72 +79,53 @@ func (c *Plugin) handleWS() {
 	var err error
 
 	// start websocket connection
-	c.connection, err = c.config.getWSConnection()
+	c.connection, err = c.connect()
 	if err != nil {
-		if c.msgHandler != nil {
-			c.msgHandler.SendMessage(plugin.Message{
-				Title:   "SMTP Emailer: Error",
-				Message: fmt.Sprintf("could not get ws connection: %v", err),
-			})
-		}
+		c.reportError(fmt.Sprintf("could not get ws connection: %v", err), false)
 		c.Disable()
 		log.Printf("SMTP Emailer: could not get ws connection: %v\n", err)
 		return
 	}
 
-	if c.config.Environment == "development" && c.msgHandler != nil {
-		c.msgHandler.SendMessage(plugin.Message{
-			Title:   "SMTP Emailer: Enabled",
-			Message: "Plugin has been enabled",
-		})
-	}
+	c.notice("Enabled", "Plugin has been enabled")
 
 	c.done = make(chan bool)
+	c.stopped = make(chan bool)
 
-	go c.handleMessages()
+	go c.handleMessages(c.done, c.stopped)
 
-	// Send test message every 10 seconds in development environment
-	if c.config.Environment == "development" && c.msgHandler != nil {
-		go func() {
-			for {
-				if !c.enabled {
-					return
-				}
-				c.msgHandler.SendMessage(plugin.Message{
-					Title:   "Test Message",
-					Message: fmt.Sprintf("config: %#v ,error: %v", c.config, c.err),
-				})
-				time.Sleep(time.Second * 10)
-			}
-		}()
+	go c.handleQuietHours(c.done)
+	go c.handleEscalations(c.done)
+
+	if c.config.Diagnostics.Heartbeat != nil && c.msgHandler != nil {
+		go c.handleHeartbeat(c.done, c.config.Diagnostics.Heartbeat)
 	}
 }
 
 // ============================================================================
 
-func (c *Plugin) handleMessages() {
-	defer close(c.done)
+func (c *Plugin) handleMessages(done <-chan bool, stopped chan<- bool) {
+	defer close(stopped)
 
 	for {
 		select {
-		case <-c.done:
+		case <-done:
 			return
 		default:
-			msg := plugin.Message{}
+			msg := Message{}
 
 			// Read message from Gotify
 			err := c.connection.ReadJSON(&msg)
 			if err != nil {
 				if _, ok := err.(*websocket.CloseError); ok {
+					// Gotify closes the connection when the client is deleted
+					if c.enabled && c.config.Client != nil && c.reconnect() {
+						continue
+					}
 					return
 				}
 				log.Printf("SMTP Emailer: connection read error: %v\n", err)
-				if c.config.Environment == "development" && c.msgHandler != nil {
-					c.msgHandler.SendMessage(plugin.Message{
-						Title:   "SMTP Emailer: Error",
-						Message: fmt.Sprintf("could not read message: %v", err),
-					})
-				}
+				c.reportError(fmt.Sprintf("could not read message: %v", err), true)
 				continue
 			}
 
@@ -149,15 +135,10 @@ func (c *Plugin) handleMessages() {
 			}
 
 			// send message to smtp
-			err = c.config.Smtp.Send(msg.Title, msg.Message)
+			err = c.send(msg)
 			if err != nil {
 				log.Printf("SMTP Emailer: smtp send error: %v\n", err)
-				if c.msgHandler != nil {
-					c.msgHandler.SendMessage(plugin.Message{
-						Title:   "SMTP Emailer: Error",
-						Message: fmt.Sprintf("smtp send error: %v", err),
-					})
-				}
+				c.reportError(fmt.Sprintf("smtp send error: %v", err), false)
 				continue
 			}
 
@@ -167,18 +148,152 @@ func (c *Plugin) handleMessages() {
 
 // ============================================================================
 
+// connect is used to connect to Gotify, a managed client is recreated when
+// its token is rejected
+func (c *Plugin) connect() (*websocket.Conn, error) {
+	token, err := c.clientToken(false)
+	if err != nil {
+		return nil, fmt.Errorf("could not get client token: %w", err)
+	}
+
+	ws, err := c.config.getWSConnection(token)
+	if errors.Is(err, errUnauthorized) && c.config.Client != nil {
+		log.Println("SMTP Emailer: client token was rejected, recreating client")
+		token, err = c.clientToken(true)
+		if err != nil {
+			return nil, fmt.Errorf("could not recreate client: %w", err)
+		}
+		ws, err = c.config.getWSConnection(token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ws, nil
+}
+
+// reconnect is used to replace a websocket connection closed by Gotify
+func (c *Plugin) reconnect() bool {
+	ws, err := c.connect()
+	if err != nil {
+		log.Printf("SMTP Emailer: could not reconnect: %v\n", err)
+		c.reportError(fmt.Sprintf("could not reconnect: %v", err), false)
+		return false
+	}
+
+	c.connection = ws
+	return true
+}
+
+// ============================================================================
+
+// send is used to send a Gotify message as an email of every matching route,
+// filtered messages are dropped and low priority messages are held during
+// quiet hours
+func (c *Plugin) send(msg Message) error {
+	if c.config.filtered(msg) {
+		log.Printf("SMTP Emailer: message %d was filtered\n", msg.ID)
+		return nil
+	}
+	routes := c.routes(msg)
+	if c.config.QuietHours != nil && c.config.QuietHours.holds(msg, time.Now()) {
+		return c.hold(msg, routes)
+	}
+
+	email := c.render(msg)
+	if c.config.Threading != nil {
+		err := c.thread(msg, email)
+		if err != nil {
+			log.Printf("SMTP Emailer: could not thread email: %v\n", err)
+		}
+	}
+
+	var errs []error
+	for _, d := range c.routeDeliveries(routes) {
+		routed, err := c.routed(msg, email, d)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		err = c.deliver(d.transport, d.smtp, routed)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	// recorded emails were never sent, so they neither start a thread nor
+	// get escalated
+	if c.recorder != nil {
+		return nil
+	}
+
+	if c.config.Threading != nil {
+		err := c.saveThread(msg, email)
+		if err != nil {
+			log.Printf("SMTP Emailer: could not save thread: %v\n", err)
+		}
+	}
+	if c.config.Escalation != nil && c.config.Escalation.escalates(msg) {
+		err := c.escalate(msg, routes)
+		if err != nil {
+			log.Printf("SMTP Emailer: could not escalate message %d: %v\n", msg.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// routed is used to get the email of a message for a delivery, the template
+// of the delivery is applied to a copy of the email
+func (c *Plugin) routed(msg Message, email *Email, d delivery) (*Email, error) {
+	routed := *email
+	if d.template != nil {
+		err := d.template.apply(&routed, c.templateData(msg))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &routed, nil
+}
+
+// deliver is used to build an email with the smtp settings and to deliver it
+// through the transport
+func (c *Plugin) deliver(t Transport, s *Smtp, email *Email) error {
+	messages, err := s.build(email)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range messages {
+		err = t.Deliver(m.from, m.to, m.content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ============================================================================
+
 // getWSConnection is used to establish as websocket connection with Gotify
-func (c *Config) getWSConnection() (*websocket.Conn, error) {
+func (c *Config) getWSConnection(token string) (*websocket.Conn, error) {
 	count := 0
 	for {
 		count++
-		uri := fmt.Sprintf("%s/stream?token=%s", c.Hostname, c.Token)
-		ws, _, err := websocket.DefaultDialer.Dial(uri, nil)
+		uri := fmt.Sprintf("%s/stream?token=%s", c.Hostname, token)
+		ws, res, err := websocket.DefaultDialer.Dial(uri, nil)
 		if err == nil {
 			return ws, nil
 		}
+		if res != nil && res.StatusCode == http.StatusUnauthorized {
+			return nil, errUnauthorized
+		}
 		if count > 60 {
-			return nil, fmt.Errorf("Cannot connect to websocket %q: %w", uri, err)
+			return nil, fmt.Errorf("Cannot connect to websocket %q: %w", c.Hostname+"/stream", err)
 
 		}
 		time.Sleep(500 * time.Millisecond)
@@ -191,26 +306,22 @@ func (c *Config) getWSConnection() (*websocket.Conn, error) {
 func (c *Plugin) Disable() error {
 	err := c.connection.Close()
 	if err != nil {
-		if c.config.Environment == "development" && c.msgHandler != nil {
-			c.msgHandler.SendMessage(plugin.Message{
-				Title:   "SMTP Emailer: Disabled",
-				Message: fmt.Sprintf("could not close ws connection: %v", err),
-			})
-		}
+		c.reportError(fmt.Sprintf("could not close ws connection: %v", err), true)
 		log.Printf("SMTP Emailer: could not close ws connection: %v\n", err)
 	}
 
-	if c.config.Environment == "development" && c.msgHandler != nil {
-		c.msgHandler.SendMessage(plugin.Message{
-			Title:   "SMTP Emailer: Disabled",
-			Message: "Plugin has been disabled",
-		})
-	}
+	c.notice("Disabled", "Plugin has been disabled")
 
-	c.done <- true
+	// closing done stops every goroutine started by handleWS, the connection
+	// is only dropped once messages are no longer read from it
+	if c.done != nil {
+		close(c.done)
+		<-c.stopped
+	}
 
 	c.connection = nil
 	c.done = nil
+	c.stopped = nil
 	c.enabled = false
 
 	return nil
@@ -220,6 +331,10 @@ func (c *Plugin) Disable() error {
 
 // RegisterWebhook implements plugin.Webhooker.
 func (c *Plugin) RegisterWebhook(basePath string, g *gin.RouterGroup) {
+	c.basePath = basePath
+	g.GET("/dryrun", c.handleDryRun)
+	g.GET("/preview", c.handlePreview)
+	g.POST("/preview", c.handlePreview)
 }
 
 // GetDisplay implements plugin.Displayer
@@ -229,7 +344,8 @@ func (c *Plugin) GetDisplay(location *url.URL) string {
 		if c.err != nil {
 			return fmt.Sprintf("There has been an error: %v", c.err)
 		}
-		return fmt.Sprintf("This plugin requires a client token to be configured. Please see %s for more information", GetGotifyPluginInfo().ModulePath)
+		return fmt.Sprintf("This plugin requires a client token or a managed client to be configured. Please see %s for more information", GetGotifyPluginInfo().ModulePath) +
+			c.displayDryRun(location)
 	} else {
 		return "You are **NOT** an admin! You can do nothing:("
 	}
@@ -15,6 +15,9 @@ import (
 
 func TestAPICompatibility(t *testing.T) {
 	require.Implements(t, (*plugin.Plugin)(nil), new(Plugin))
+	require.Implements(t, (*plugin.Storager)(nil), new(Plugin))
+	require.Implements(t, (*plugin.Webhooker)(nil), new(Plugin))
+	require.Implements(t, (*plugin.Displayer)(nil), new(Plugin))
 	// Add other interfaces you intend to implement here
 }
 
@@ -23,13 +26,14 @@ func TestAPI(t *testing.T) {
 	defer stop(t, s)
 
 	cfg := baseConfig
+	cfg.Version = currentConfigVersion
 	cfg.Hostname = s.Url
 	cfg.Token = s.Token
-	cfg.Environment = "development"
+	cfg.Diagnostics = Diagnostics{LogLevel: logDebug, Notices: true, ReportErrors: reportAll}
 	cfg.Smtp = Smtp{
 		Host:     "localhost",
 		Port:     s.MailhogPort,
-		Insecure: true,
+		TLSMode:  tlsNone,
 		Username: "from@email.com",
 		Password: toPtr("password"),
 		Subject:  toPtr("Test Subject"),
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// preview represents an email rendered for a message without sending it
+type preview struct {
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	HTML    string   `json:"html"`
+	Text    string   `json:"text"`
+	Raw     string   `json:"raw"` // RFC 5322 source of the email
+}
+
+// ============================================================================
+
+// preview is used to render the emails of every route of a message. Filters,
+// quiet hours and threading are left out.
+func (c *Plugin) preview(msg Message) ([]preview, error) {
+	email := c.render(msg)
+
+	var previews []preview
+	for _, d := range c.deliveries(msg) {
+		routed, err := c.routed(msg, email, d)
+		if err != nil {
+			return nil, err
+		}
+		messages, err := d.smtp.build(routed)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range messages {
+			previews = append(previews, preview{
+				To:      m.to,
+				Subject: subject(m.content),
+				HTML:    routed.HTML,
+				Text:    routed.Text,
+				Raw:     string(m.content),
+			})
+		}
+	}
+
+	return previews, nil
+}
+
+// handlePreview is used to preview the emails of a message. The message is a
+// Gotify message id, /preview?id=1, or a JSON sample posted to /preview.
+func (c *Plugin) handlePreview(ctx *gin.Context) {
+	if c.config == nil {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "no config set"})
+		return
+	}
+
+	var msg Message
+	if ctx.Request.Method == http.MethodPost {
+		err := ctx.ShouldBindJSON(&msg)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid message: " + err.Error()})
+			return
+		}
+	} else {
+		id, err := strconv.ParseUint(ctx.Query("id"), 10, 32)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+			return
+		}
+		var ok bool
+		msg, ok, err = c.message(uint(id))
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{"error": "could not get message: " + err.Error()})
+			return
+		}
+		if !ok {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+			return
+		}
+	}
+
+	previews, err := c.preview(msg)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not render message: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, previews)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreview(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/application" {
+			fmt.Fprint(w, `[{"id":3,"name":"backup"}]`)
+			return
+		}
+		require.Equal(t, "/message", r.URL.Path)
+		if r.URL.Query().Get("since") == "6" {
+			fmt.Fprint(w, `{"messages":[{"id":5,"appid":3,"title":"real title","message":"real message","priority":5}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"messages":[]}`)
+	}))
+	defer srv.Close()
+
+	cfg := baseConfig
+	cfg.Hostname = strings.Replace(srv.URL, "http://", "ws://", 1)
+	cfg.Token = "token"
+	cfg.Templates = map[string]Template{"urgent": {Subject: toPtr("URGENT {{.Title}}")}}
+	cfg.Routes = []Route{{When: "priority >= 8", ToEmails: []string{"ops@email.com"}, Template: toPtr("urgent")}}
+
+	p := &Plugin{}
+	require.NoError(t, p.ValidateAndSetConfig(&cfg))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	p.RegisterWebhook("/", router.Group("/"))
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		code    int
+		to      []string
+		subject string
+		text    string
+	}{
+		{
+			name:    "should preview gotify message",
+			method:  http.MethodGet,
+			target:  "/preview?id=5",
+			code:    http.StatusOK,
+			to:      []string{"to@email.com"},
+			subject: "Test Subject: real title",
+			text:    "real title\n\nreal message\n\nApp: backup\nPriority: 5 (normal)\n",
+		},
+		{
+			name:    "should preview routed sample",
+			method:  http.MethodPost,
+			target:  "/preview",
+			body:    `{"title":"sample title","message":"sample message","priority":9}`,
+			code:    http.StatusOK,
+			to:      []string{"ops@email.com"},
+			subject: "Test Subject: URGENT sample title",
+			text:    "sample title\n\nsample message\n\nPriority: 9 (high)\n",
+		},
+		{name: "should not find deleted message", method: http.MethodGet, target: "/preview?id=7", code: http.StatusNotFound},
+		{name: "should not accept invalid id", method: http.MethodGet, target: "/preview?id=abc", code: http.StatusBadRequest},
+		{name: "should not accept invalid sample", method: http.MethodPost, target: "/preview", body: `{"title":`, code: http.StatusBadRequest},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			require.Equal(t, tt.code, res.Code, res.Body.String())
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			var previews []preview
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &previews))
+			require.Len(t, previews, 1)
+			require.Equal(t, tt.to, previews[0].To)
+			require.Equal(t, tt.subject, previews[0].Subject)
+			require.Equal(t, tt.text, previews[0].Text)
+			require.Contains(t, previews[0].HTML, "<h3>")
+			require.True(t, strings.HasPrefix(previews[0].Raw, "From: "))
+		}
+
+		t.Run(tt.name, test)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	// timezones are embedded as the Gotify image may not have them installed
+	_ "time/tzdata"
+)
+
+const (
+	defaultQuietMinPriority = 8
+
+	// maxHeld is the number of messages held during quiet hours, the oldest
+	// messages are dropped first
+	maxHeld = 500
+
+	// quietHoursInterval is how often held messages are checked for
+	quietHoursInterval = time.Minute
+)
+
+// QuietHours represents the schedule low priority emails are held during
+type QuietHours struct {
+	Timezone    *string       // Optional: IANA timezone of the periods like Europe/Berlin, defaults to the timezone of the config
+	Periods     []QuietPeriod // Periods of quiet hours
+	MinPriority *int          // Optional: lowest priority still sent during quiet hours, defaults to 8
+
+	fallback *time.Location // timezone of the config, set when the config is validated
+}
+
+// heldMessage represents a persisted message held during quiet hours
+type heldMessage struct {
+	Message
+	Routes []int `json:",omitempty"` // Routes the message matched when it was held
+}
+
+// QuietPeriod represents a recurring period of quiet hours
+type QuietPeriod struct {
+	Days  []string // Optional: weekdays the period starts on like mon or tue, defaults to every day
+	Start string   // Start time like 22:00
+	End   string   // End time like 07:00, before the start for periods over midnight
+}
+
+// ============================================================================
+
+// isValid is used to validate the quiet hours configuration
+func (q *QuietHours) isValid() error {
+	v := &validation{}
+	if q.Timezone != nil {
+		_, err := time.LoadLocation(*q.Timezone)
+		if err != nil {
+			v.addf("timezone", "the timezone %q is not valid", *q.Timezone)
+		}
+	}
+	if len(q.Periods) == 0 {
+		v.add("periods", errors.New("at least one period is needed"))
+	}
+	for i := range q.Periods {
+		v.add(fmt.Sprintf("periods[%d]", i), q.Periods[i].isValid())
+	}
+	if q.MinPriority != nil && (*q.MinPriority < 0 || *q.MinPriority > 10) {
+		v.addf("minpriority", "the min priority %d is not between 0 and 10", *q.MinPriority)
+	}
+
+	return v.err()
+}
+
+// isValid is used to validate the quiet period configuration
+func (p *QuietPeriod) isValid() error {
+	v := &validation{}
+	for i, day := range p.Days {
+		_, err := parseWeekday(day)
+		v.add(fmt.Sprintf("days[%d]", i), err)
+	}
+	_, err := parseClock(p.Start)
+	v.add("start", err)
+	_, err = parseClock(p.End)
+	v.add("end", err)
+
+	return v.err()
+}
+
+// ============================================================================
+
+// location is used to get the timezone of the quiet hours, it falls back to
+// the timezone of the config and then the server timezone
+func (q *QuietHours) location() *time.Location {
+	fallback := time.Local
+	if q.fallback != nil {
+		fallback = q.fallback
+	}
+	if q.Timezone == nil {
+		return fallback
+	}
+	loc, err := time.LoadLocation(*q.Timezone)
+	if err != nil {
+		return fallback
+	}
+
+	return loc
+}
+
+// active is used to check if a time is within quiet hours
+func (q *QuietHours) active(t time.Time) bool {
+	t = t.In(q.location())
+	for i := range q.Periods {
+		if q.Periods[i].active(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// holds is used to check if a message is held at a time
+func (q *QuietHours) holds(msg Message, t time.Time) bool {
+	minPriority := defaultQuietMinPriority
+	if q.MinPriority != nil {
+		minPriority = *q.MinPriority
+	}
+
+	return msg.Priority < minPriority && q.active(t)
+}
+
+// active is used to check if a time in the timezone of the quiet hours is
+// within the period. A period with the same start and end lasts all day.
+func (p *QuietPeriod) active(t time.Time) bool {
+	start, err := parseClock(p.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(p.End)
+	if err != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+
+	switch {
+	case start == end:
+		return p.startsOn(t.Weekday())
+	case start < end:
+		return p.startsOn(t.Weekday()) && now >= start && now < end
+	default:
+		// the period lasts over midnight into the next day
+		return (p.startsOn(t.Weekday()) && now >= start) ||
+			(p.startsOn(t.AddDate(0, 0, -1).Weekday()) && now < end)
+	}
+}
+
+// startsOn is used to check if the period starts on a weekday
+func (p *QuietPeriod) startsOn(day time.Weekday) bool {
+	if len(p.Days) == 0 {
+		return true
+	}
+	for _, d := range p.Days {
+		weekday, err := parseWeekday(d)
+		if err == nil && weekday == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseClock is used to parse a time like 22:00 into minutes since midnight
+func parseClock(s string) (int, error) {
+	hours, minutes, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, fmt.Errorf("the time %q is not like 22:00", s)
+	}
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 23 {
+		return 0, fmt.Errorf("the time %q is not like 22:00", s)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 {
+		return 0, fmt.Errorf("the time %q is not like 22:00", s)
+	}
+
+	return h*60 + m, nil
+}
+
+// parseWeekday is used to parse a weekday like mon or monday
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, nil
+		}
+	}
+
+	return 0, fmt.Errorf("the weekday %q is not valid", s)
+}
+
+// ============================================================================
+
+// hold is used to persist a message with its routes until quiet hours end
+func (c *Plugin) hold(msg Message, routes []int) error {
+	return c.updateStorage(func(data *storageData) {
+		data.Held = append(data.Held, heldMessage{Message: msg, Routes: routes})
+		if len(data.Held) > maxHeld {
+			log.Printf("SMTP Emailer: dropping %d held messages\n", len(data.Held)-maxHeld)
+			data.Held = data.Held[len(data.Held)-maxHeld:]
+		}
+	})
+}
+
+// catchUp is used to send the held messages once quiet hours have ended,
+// messages of the same routes are sent as one email through those routes
+func (c *Plugin) catchUp() error {
+	if c.config.QuietHours == nil || c.config.QuietHours.active(time.Now()) {
+		return nil
+	}
+
+	data, err := c.loadStorage()
+	if err != nil {
+		return err
+	}
+	if len(data.Held) == 0 {
+		return nil
+	}
+
+	type digest struct {
+		routes []int
+		held   []int
+	}
+	var digests []*digest
+	byRoutes := map[string]*digest{}
+	for i, h := range data.Held {
+		routes := h.Routes
+		if routes == nil {
+			// held before routes were kept with the message
+			routes = c.routes(h.Message)
+		}
+		key := fmt.Sprint(routes)
+		d, ok := byRoutes[key]
+		if !ok {
+			d = &digest{routes: routes}
+			byRoutes[key] = d
+			digests = append(digests, d)
+		}
+		d.held = append(d.held, i)
+	}
+
+	sent := map[uint]bool{}
+	var errs []error
+	for _, d := range digests {
+		msgs := make([]Message, 0, len(d.held))
+		for _, i := range d.held {
+			msgs = append(msgs, data.Held[i].Message)
+		}
+		email := c.config.renderDigest(msgs)
+
+		failed := false
+		for _, delivery := range c.routeDeliveries(d.routes) {
+			err = c.deliver(delivery.transport, delivery.smtp, email)
+			if err != nil {
+				errs = append(errs, err)
+				failed = true
+			}
+		}
+		if failed {
+			continue
+		}
+		for _, i := range d.held {
+			sent[data.Held[i].ID] = true
+		}
+	}
+
+	// messages held while sending are kept for the next catch up, the held
+	// messages may have been trimmed meanwhile so sent ones are matched by id
+	err = c.updateStorage(func(data *storageData) {
+		held := data.Held[:0]
+		for _, h := range data.Held {
+			if !sent[h.ID] {
+				held = append(held, h)
+			}
+		}
+		data.Held = held
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// handleQuietHours is used to send the held messages once quiet hours have
+// ended until done is closed, it runs without quiet hours so they can be
+// added while the plugin is enabled
+func (c *Plugin) handleQuietHours(done <-chan bool) {
+	ticker := time.NewTicker(quietHoursInterval)
+	defer ticker.Stop()
+
+	for {
+		err := c.catchUp()
+		if err != nil {
+			log.Printf("SMTP Emailer: could not send held messages: %v\n", err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ============================================================================
+
+// renderDigest is used to render the messages held during quiet hours into
+// one email
+func (c *Config) renderDigest(msgs []Message) *Email {
+	loc := time.Local
+	if c.QuietHours != nil {
+		loc = c.QuietHours.location()
+	}
+
+	email := Email{Subject: fmt.Sprintf("%d messages held during quiet hours", len(msgs))}
+
+	var text strings.Builder
+	text.WriteString(email.Subject + "\n")
+
+	body := "<div>"
+	body += "<h3>" + email.Subject + "</h3>"
+	for _, msg := range msgs {
+		date := msg.Date.In(loc).Format("Mon, 02 Jan 2006 15:04 MST")
+		body += "<h4>" + msg.Title + "</h4>"
+		body += "<p>" + msg.Message + "</p>"
+		body += "<p style=\"color: #757575; font-size: 12px;\">" + date + "</p>"
+		text.WriteString(fmt.Sprintf("\n%s\n%s\n%s\n", msg.Title, msg.Message, date))
+	}
+	body += "</div>"
+
+	email.HTML = body
+	email.Text = text.String()
+
+	return &email
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryTransport implements Transport
+// It is used to keep the delivered emails, or to fail with err when set
+type memoryTransport struct {
+	sent      [][]byte
+	err       error
+	delivered func() // called while delivering, optional
+}
+
+func (m *memoryTransport) Deliver(from string, to []string, content []byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.delivered != nil {
+		m.delivered()
+	}
+	m.sent = append(m.sent, content)
+	return nil
+}
+
+func TestQuietHoursActive(t *testing.T) {
+	q := QuietHours{
+		Timezone: toPtr("Europe/Berlin"),
+		Periods: []QuietPeriod{
+			{Start: "22:00", End: "07:00"},
+			{Days: []string{"sat", "Sunday"}, Start: "00:00", End: "00:00"},
+		},
+	}
+	require.NoError(t, q.isValid())
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		time   time.Time
+		active bool
+	}{
+		{name: "should be active late in the evening", time: time.Date(2026, 10, 14, 23, 30, 0, 0, berlin), active: true},
+		{name: "should be active after midnight", time: time.Date(2026, 10, 15, 6, 59, 0, 0, berlin), active: true},
+		{name: "should not be active at the end", time: time.Date(2026, 10, 15, 7, 0, 0, 0, berlin)},
+		{name: "should not be active during the day", time: time.Date(2026, 10, 15, 12, 0, 0, 0, berlin)},
+		{name: "should be active all day on weekends", time: time.Date(2026, 10, 17, 12, 0, 0, 0, berlin), active: true},
+		{name: "should use the timezone", time: time.Date(2026, 10, 15, 21, 30, 0, 0, time.UTC), active: true},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			require.Equal(t, tt.active, q.active(tt.time))
+		}
+
+		t.Run(tt.name, test)
+	}
+
+	night := time.Date(2026, 10, 14, 23, 30, 0, 0, berlin)
+	require.True(t, q.holds(Message{Priority: 5}, night))
+	require.False(t, q.holds(Message{Priority: 8}, night))
+	q.MinPriority = toPtr(10)
+	require.True(t, q.holds(Message{Priority: 8}, night))
+}
+
+func TestQuietHoursTimezone(t *testing.T) {
+	cfg := baseConfig
+	cfg.Timezone = toPtr("Europe/Berlin")
+	cfg.QuietHours = &QuietHours{Periods: []QuietPeriod{{Start: "22:00", End: "07:00"}}}
+	require.NoError(t, cfg.IsValid())
+
+	require.True(t, cfg.QuietHours.active(time.Date(2026, 10, 15, 21, 30, 0, 0, time.UTC)))
+	require.False(t, cfg.QuietHours.active(time.Date(2026, 10, 15, 6, 30, 0, 0, time.UTC)))
+	t.Logf("\tP\tshould fall back to the timezone of the config")
+
+	cfg.QuietHours.Timezone = toPtr("UTC")
+	require.NoError(t, cfg.IsValid())
+	require.False(t, cfg.QuietHours.active(time.Date(2026, 10, 15, 21, 30, 0, 0, time.UTC)))
+	t.Logf("\tP\tshould prefer the timezone of the quiet hours")
+}
+
+func TestQuietHoursIsValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		quiet QuietHours
+	}{
+		{name: "should not accept no periods", quiet: QuietHours{}},
+		{name: "should not accept invalid timezone", quiet: QuietHours{Timezone: toPtr("Mars/Olympus"), Periods: []QuietPeriod{{Start: "22:00", End: "07:00"}}}},
+		{name: "should not accept invalid day", quiet: QuietHours{Periods: []QuietPeriod{{Days: []string{"someday"}, Start: "22:00", End: "07:00"}}}},
+		{name: "should not accept invalid time", quiet: QuietHours{Periods: []QuietPeriod{{Start: "25:00", End: "07:00"}}}},
+		{name: "should not accept invalid priority", quiet: QuietHours{Periods: []QuietPeriod{{Start: "22:00", End: "07:00"}}, MinPriority: toPtr(11)}},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			require.Error(t, tt.quiet.isValid())
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestQuietHoursCatchUp(t *testing.T) {
+	cfg := baseConfig
+	cfg.QuietHours = &QuietHours{Periods: []QuietPeriod{{Start: "00:00", End: "00:00"}}}
+	transport := &memoryTransport{}
+	p := &Plugin{config: &cfg, transport: transport}
+	p.SetStorageHandler(&memoryStorage{})
+
+	require.NoError(t, p.send(Message{Title: "first title", Message: "first message", Priority: 1}))
+	require.NoError(t, p.send(Message{Title: "second title", Message: "second message", Priority: 2}))
+	require.Empty(t, transport.sent)
+	t.Logf("\tP\tshould hold low priority messages")
+
+	require.NoError(t, p.send(Message{Title: "urgent title", Message: "urgent message", Priority: 9}))
+	require.Len(t, transport.sent, 1)
+	t.Logf("\tP\tshould send urgent messages")
+
+	require.NoError(t, p.catchUp())
+	require.Len(t, transport.sent, 1)
+	t.Logf("\tP\tshould not catch up during quiet hours")
+
+	cfg.QuietHours.Periods[0].Days = []string{time.Now().AddDate(0, 0, 1).Weekday().String()}
+	require.NoError(t, p.catchUp())
+	require.Len(t, transport.sent, 2)
+	digest := string(transport.sent[1])
+	require.Contains(t, digest, "2 messages held during quiet hours")
+	require.Contains(t, digest, "first message")
+	require.Contains(t, digest, "second message")
+	t.Logf("\tP\tshould send held messages as one email")
+
+	require.NoError(t, p.catchUp())
+	require.Len(t, transport.sent, 2)
+	data, err := p.loadStorage()
+	require.NoError(t, err)
+	require.Empty(t, data.Held)
+	t.Logf("\tP\tshould clear held messages")
+}
+
+func TestQuietHoursCatchUpRoutes(t *testing.T) {
+	cfg := baseConfig
+	cfg.QuietHours = &QuietHours{Periods: []QuietPeriod{{Start: "00:00", End: "00:00"}}}
+	cfg.Routes = []Route{{When: "priority <= 2", ToEmails: []string{"ops@email.com"}}}
+	require.NoError(t, cfg.IsValid())
+	transport := &memoryTransport{}
+	p := &Plugin{config: &cfg, transport: transport}
+	p.SetStorageHandler(&memoryStorage{})
+
+	require.NoError(t, p.send(Message{Title: "routed title", Message: "routed message", Priority: 1}))
+	require.NoError(t, p.send(Message{Title: "other title", Message: "other message", Priority: 5}))
+	data, err := p.loadStorage()
+	require.NoError(t, err)
+	require.Len(t, data.Held, 2)
+	require.Equal(t, []int{0}, data.Held[0].Routes)
+	require.Equal(t, []int{defaultRoute}, data.Held[1].Routes)
+	t.Logf("\tP\tshould hold messages with their routes")
+
+	cfg.QuietHours.Periods[0].Days = []string{time.Now().AddDate(0, 0, 1).Weekday().String()}
+	require.NoError(t, p.catchUp())
+	require.Len(t, transport.sent, 2)
+	require.Contains(t, string(transport.sent[0]), "To: ops@email.com")
+	require.Contains(t, string(transport.sent[0]), "routed message")
+	require.NotContains(t, string(transport.sent[0]), "other message")
+	require.Contains(t, string(transport.sent[1]), "To: to@email.com")
+	require.Contains(t, string(transport.sent[1]), "other message")
+	t.Logf("\tP\tshould send held messages through their routes")
+}
+
+func TestQuietHoursCatchUpTrimmed(t *testing.T) {
+	cfg := baseConfig
+	cfg.QuietHours = &QuietHours{Periods: []QuietPeriod{{Days: []string{time.Now().AddDate(0, 0, 1).Weekday().String()}, Start: "00:00", End: "00:00"}}}
+	transport := &memoryTransport{}
+	p := &Plugin{config: &cfg, transport: transport}
+	p.SetStorageHandler(&memoryStorage{})
+
+	require.NoError(t, p.updateStorage(func(data *storageData) {
+		for i := 1; i <= maxHeld; i++ {
+			data.Held = append(data.Held, heldMessage{Message: Message{ID: uint(i), Title: "held title", Priority: 1}, Routes: []int{defaultRoute}})
+		}
+	}))
+	transport.delivered = func() {
+		transport.delivered = nil
+		require.NoError(t, p.hold(Message{ID: maxHeld + 1, Title: "late title", Priority: 1}, []int{defaultRoute}))
+		require.NoError(t, p.hold(Message{ID: maxHeld + 2, Title: "late title", Priority: 1}, []int{defaultRoute}))
+	}
+
+	require.NoError(t, p.catchUp())
+	require.Len(t, transport.sent, 1)
+	data, err := p.loadStorage()
+	require.NoError(t, err)
+	require.Len(t, data.Held, 2)
+	require.Equal(t, uint(maxHeld+1), data.Held[0].ID)
+	require.Equal(t, uint(maxHeld+2), data.Held[1].ID)
+	t.Logf("\tP\tshould keep messages held while the oldest were dropped")
+}
+
+func TestHandleQuietHours(t *testing.T) {
+	cfg := baseConfig
+	p := &Plugin{config: &cfg, transport: &memoryTransport{}}
+	p.SetStorageHandler(&memoryStorage{})
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		p.handleQuietHours(done)
+		close(stopped)
+	}()
+
+	close(done)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("quiet hours did not stop")
+	}
+	t.Logf("\tP\tshould run without quiet hours and stop when done is closed")
+}
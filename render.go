@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strings"
+)
+
+const (
+	// dateFormat is how message dates are shown in emails
+	dateFormat = "Mon, 02 Jan 2006 15:04 MST"
+
+	lowPriorityColor    = "#4caf50"
+	normalPriorityColor = "#ff9800"
+	highPriorityColor   = "#f44336"
+)
+
+// Email represents an email rendered from a Gotify message
+type Email struct {
+	Subject string
+	HTML    string
+	Text    string       // Optional: plain text alternative of the html
+	Inline  []Attachment // Optional: files referenced from the html by content id
+	// Optional: Message-ID of the email, generated when empty
+	MessageID string
+	// Optional: Message-ID of the thread root the email replies to
+	InReplyTo string
+	// Optional: additional header fields of the email
+	Headers []headerField
+}
+
+// source represents the Gotify application that sent a message
+type source struct {
+	Name string
+	Icon *Attachment // Optional: icon embedded next to the name
+}
+
+// ============================================================================
+
+// render is used to render a Gotify message into an email with the
+// application that sent it
+func (c *Plugin) render(msg Message) *Email {
+	app, ok := c.application(msg.AppID)
+	if !ok {
+		return c.config.render(msg, nil)
+	}
+
+	return c.config.render(msg, &source{Name: app.Name, Icon: c.appIcon(app)})
+}
+
+// render is used to render a Gotify message into an email, the source is nil
+// when the application is unknown
+func (c *Config) render(msg Message, src *source) *Email {
+	var app string
+	if src != nil {
+		app = src.Name
+	}
+	email := Email{Subject: msg.Title, Headers: c.Smtp.headers(msg, app)}
+
+	var text strings.Builder
+	text.WriteString(msg.Title + "\n\n")
+	text.WriteString(msg.Message + "\n\n")
+
+	body := "<div>"
+	body += c.renderSummary(msg, src, &email, &text)
+	body += "<h3>"
+	body += msg.Title
+	body += "</h3>"
+	body += "<p>"
+	body += msg.Message
+	body += "</p>"
+
+	imageURL := msg.extraString("client::notification", "bigImageUrl")
+	if imageURL != "" {
+		var image *Attachment
+		if !c.Images.Disabled {
+			var err error
+			image, err = c.Images.fetch(imageURL)
+			if err != nil {
+				log.Printf("SMTP Emailer: could not embed image, linking it instead: %v\n", err)
+			}
+		}
+
+		if image != nil {
+			email.Inline = append(email.Inline, *image)
+			body += fmt.Sprintf("<p><img src=\"cid:%s\" alt=\"%s\" style=\"max-width: 100%%;\"></p>",
+				image.ContentID, html.EscapeString(image.Filename))
+		} else {
+			body += fmt.Sprintf("<p><a href=\"%s\">View image</a></p>", html.EscapeString(imageURL))
+		}
+		text.WriteString(fmt.Sprintf("\nImage: %s\n", imageURL))
+	}
+
+	actions := c.actions(msg)
+	if len(actions) > 0 {
+		body += "<p>"
+		text.WriteString("\n")
+		for _, a := range actions {
+			body += fmt.Sprintf("<a href=\"%s\" style=\"display: inline-block; margin: 0 8px 8px 0; padding: 10px 16px; "+
+				"border-radius: 4px; background-color: #3f51b5; color: #ffffff; font-weight: bold; text-decoration: none;\">%s</a>",
+				html.EscapeString(a.URL), html.EscapeString(a.Label))
+			text.WriteString(fmt.Sprintf("%s: %s\n", a.Label, a.URL))
+		}
+		body += "</p>"
+	}
+
+	link := c.gotifyURL(msg)
+	if link != "" {
+		body += fmt.Sprintf("<p style=\"color: #757575; font-size: 12px;\"><a href=\"%s\">View in Gotify</a></p>",
+			html.EscapeString(link))
+		text.WriteString(fmt.Sprintf("\nView in Gotify: %s\n", link))
+	}
+
+	body += "</div>"
+	email.HTML = body
+	email.Text = text.String()
+
+	return &email
+}
+
+// renderSummary is used to render the application, priority badge and date
+// shown above the message
+func (c *Config) renderSummary(msg Message, src *source, email *Email, text *strings.Builder) string {
+	label, color := priorityLevel(msg.Priority)
+
+	summary := "<table role=\"presentation\" style=\"border-collapse: collapse; margin-bottom: 8px;\"><tr>"
+	if src != nil && src.Icon != nil {
+		email.Inline = append(email.Inline, *src.Icon)
+		summary += fmt.Sprintf("<td style=\"padding: 0 8px 0 0;\"><img src=\"cid:%s\" alt=\"\" width=\"32\" height=\"32\"></td>",
+			src.Icon.ContentID)
+	}
+	summary += "<td style=\"padding: 0 8px 0 0;\">"
+	if src != nil && src.Name != "" {
+		summary += "<strong>" + html.EscapeString(src.Name) + "</strong><br>"
+		text.WriteString("App: " + src.Name + "\n")
+	}
+	if !msg.Date.IsZero() {
+		date := msg.Date.In(c.location()).Format(dateFormat)
+		summary += "<span style=\"color: #757575; font-size: 12px;\">" + date + "</span>"
+		text.WriteString("Date: " + date + "\n")
+	}
+	summary += "</td>"
+	summary += fmt.Sprintf("<td style=\"padding: 0;\"><span style=\"display: inline-block; padding: 2px 8px; "+
+		"border-radius: 10px; background-color: %s; color: #ffffff; font-size: 12px; font-weight: bold;\">"+
+		"Priority %d</span></td>", color, msg.Priority)
+	summary += "</tr></table>"
+	text.WriteString(fmt.Sprintf("Priority: %d (%s)\n", msg.Priority, label))
+
+	return summary
+}
+
+// priorityLevel is used to get the level and badge colour of a priority like
+// the Gotify clients group them
+func priorityLevel(priority int) (string, string) {
+	switch {
+	case priority >= 8:
+		return "high", highPriorityColor
+	case priority >= 4:
+		return "normal", normalPriorityColor
+	default:
+		return "low", lowPriorityColor
+	}
+}
+
+// gotifyURL is used to link the messages of the application in the Gotify web
+// UI, empty without a base url
+func (c *Config) gotifyURL(msg Message) string {
+	if c.BaseURL == nil {
+		return ""
+	}
+	u, err := url.Parse(*c.BaseURL)
+	if err != nil {
+		return ""
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = "/"
+	if msg.AppID != 0 {
+		u.Fragment = fmt.Sprintf("/messages/%d", msg.AppID)
+	}
+
+	return u.String()
+}
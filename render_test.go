@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderSummary(t *testing.T) {
+	date := time.Date(2024, 6, 23, 17, 12, 0, 0, time.UTC)
+	icon := &Attachment{ContentID: "icon@gotify-smtp-emailer", ContentType: "image/png", Filename: "app.png"}
+
+	tests := []struct {
+		name     string
+		timezone *string
+		baseURL  *string
+		msg      Message
+		src      *source
+		html     []string
+		text     []string
+		inline   int
+	}{
+		{
+			name: "should show application, icon, date and priority",
+			msg:  Message{AppID: 3, Title: "title", Message: "message", Priority: 9, Date: date},
+			src:  &source{Name: "<backup>", Icon: icon},
+			html: []string{
+				`<img src="cid:icon@gotify-smtp-emailer"`,
+				"<strong>&lt;backup&gt;</strong>",
+				"Sun, 23 Jun 2024 17:12 UTC",
+				"background-color: " + highPriorityColor,
+				"Priority 9",
+			},
+			text:   []string{"App: <backup>\n", "Date: Sun, 23 Jun 2024 17:12 UTC\n", "Priority: 9 (high)\n"},
+			inline: 1,
+		},
+		{
+			name:     "should show date in timezone",
+			timezone: toPtr("Europe/Berlin"),
+			msg:      Message{Title: "title", Priority: 5, Date: date},
+			html:     []string{"Sun, 23 Jun 2024 19:12 CEST", "background-color: " + normalPriorityColor},
+			text:     []string{"Date: Sun, 23 Jun 2024 19:12 CEST\n", "Priority: 5 (normal)\n"},
+		},
+		{
+			name:    "should link to application in gotify",
+			baseURL: toPtr("https://example.com/gotify"),
+			msg:     Message{AppID: 3, Title: "title", Priority: 1},
+			html:    []string{`<a href="https://example.com/gotify#/messages/3">View in Gotify</a>`, "background-color: " + lowPriorityColor},
+			text:    []string{"View in Gotify: https://example.com/gotify#/messages/3\n", "Priority: 1 (low)\n"},
+		},
+		{
+			name:    "should link to gotify without application",
+			baseURL: toPtr("https://gotify.example.com"),
+			msg:     Message{Title: "title"},
+			html:    []string{`<a href="https://gotify.example.com/#/">View in Gotify</a>`},
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			cfg := baseConfig
+			cfg.Timezone = tt.timezone
+			cfg.BaseURL = tt.baseURL
+
+			email := cfg.render(tt.msg, tt.src)
+			for _, want := range tt.html {
+				require.Contains(t, email.HTML, want)
+			}
+			for _, want := range tt.text {
+				require.Contains(t, email.Text, want)
+			}
+			require.Len(t, email.Inline, tt.inline)
+			if tt.baseURL == nil {
+				require.NotContains(t, email.HTML, "View in Gotify")
+			}
+			if tt.src == nil {
+				require.NotContains(t, email.Text, "App:")
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestPluginRender(t *testing.T) {
+	var img bytes.Buffer
+	require.NoError(t, png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 8, 8))))
+
+	icons := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "token", r.Header.Get("X-Gotify-Key"))
+		switch r.URL.Path {
+		case "/application":
+			fmt.Fprint(w, `[{"id":3,"name":"backup","image":"image/backup.png"},{"id":4,"name":"broken","image":"image/missing.png"}]`)
+		case "/image/backup.png":
+			icons++
+			w.Write(img.Bytes())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := baseConfig
+	cfg.Hostname = strings.Replace(srv.URL, "http://", "ws://", 1)
+	p := &Plugin{config: &cfg}
+
+	email := p.render(Message{AppID: 3, Title: "title", Priority: 5})
+	require.Contains(t, email.HTML, "<strong>backup</strong>")
+	require.Len(t, email.Inline, 1)
+	require.Equal(t, "image/png", email.Inline[0].ContentType)
+	t.Logf("\tP\tshould embed application icon")
+
+	p.render(Message{AppID: 3, Title: "title"})
+	require.Equal(t, 1, icons)
+	t.Logf("\tP\tshould cache application icon")
+
+	email = p.render(Message{AppID: 4, Title: "title"})
+	require.Contains(t, email.HTML, "<strong>broken</strong>")
+	require.Empty(t, email.Inline)
+	t.Logf("\tP\tshould render without a missing icon")
+
+	cfg.Images.Disabled = true
+	p.icons = nil
+	email = p.render(Message{AppID: 3, Title: "title"})
+	require.Empty(t, email.Inline)
+	t.Logf("\tP\tshould not embed icon when images are disabled")
+}
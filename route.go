@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Route represents a routing rule, routes are processed in order and every
+// matching route emails the message
+type Route struct {
+	Name      *string  // Optional: name of the route used in logs
+	When      string   // Expression messages must match, for example priority >= 8 and app == 'backup'
+	ToEmails  []string // Optional: recipients, defaults to the smtp to and cc emails
+	Template  *string  // Optional: name of the template used
+	Transport *string  // Optional: name of the transport used, defaults to the output
+	Stop      bool     // Optional: later routes are not processed when the route matches
+
+	when expr
+}
+
+// defaultRoute is the route of messages matching no route, they are emailed
+// to the smtp recipients through the output
+const defaultRoute = -1
+
+// delivery represents how a message is emailed
+type delivery struct {
+	smtp      *Smtp
+	template  *Template
+	transport Transport
+}
+
+// ============================================================================
+
+// isValid is used to validate the route configuration against the named
+// templates and transports of the config
+func (r *Route) isValid(c *Config) error {
+	v := &validation{}
+	if r.When == "" {
+		v.add("when", errors.New("the when expression is required"))
+	} else {
+		when, err := compileExpr(r.When)
+		if err != nil {
+			v.addf("when", "the when expression is not valid: %w", err)
+		}
+		r.when = when
+	}
+
+	v.emails("toemails", r.ToEmails, false)
+	if r.Template != nil {
+		if _, ok := c.Templates[*r.Template]; !ok {
+			v.addf("template", "the template %q does not exist", *r.Template)
+		}
+	}
+	if r.Transport != nil {
+		if _, ok := c.Transports[*r.Transport]; !ok {
+			v.addf("transport", "the transport %q does not exist", *r.Transport)
+		}
+	}
+
+	return v.err()
+}
+
+// name is used to get the name of the route used in logs
+func (r *Route) name(i int) string {
+	if r.Name != nil {
+		return *r.Name
+	}
+	return fmt.Sprintf("%d", i)
+}
+
+// ============================================================================
+
+// location is used to get the timezone of the config
+func (c *Config) location() *time.Location {
+	if c.Timezone == nil {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(*c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+
+	return loc
+}
+
+// routes is used to get the indexes of the routes matching a message, a
+// message matching no route gets the default route
+func (c *Plugin) routes(msg Message) []int {
+	var app *string
+	env := &exprEnv{
+		msg: msg,
+		now: time.Now().In(c.config.location()),
+		app: func() string {
+			if app == nil {
+				name := c.appName(msg.AppID)
+				app = &name
+			}
+			return *app
+		},
+	}
+
+	var routes []int
+	for i := range c.config.Routes {
+		r := &c.config.Routes[i]
+		// routes are compiled by isValid, a route that did not compile is
+		// skipped
+		if r.when == nil || !r.when.eval(env) {
+			continue
+		}
+
+		routes = append(routes, i)
+		if r.Stop {
+			break
+		}
+	}
+
+	if len(routes) == 0 {
+		routes = append(routes, defaultRoute)
+	}
+
+	return routes
+}
+
+// deliveries is used to route a message, a message matching no route is
+// emailed to the smtp recipients through the output
+func (c *Plugin) deliveries(msg Message) []delivery {
+	return c.routeDeliveries(c.routes(msg))
+}
+
+// routeDeliveries is used to get how a message of the routes is emailed,
+// routes that no longer exist since the message was routed get the default
+func (c *Plugin) routeDeliveries(routes []int) []delivery {
+	deliveries := make([]delivery, 0, len(routes))
+	for _, i := range routes {
+		d := delivery{smtp: &c.config.Smtp, transport: c.transport}
+		if i == defaultRoute {
+			deliveries = append(deliveries, d)
+			continue
+		}
+		if i < 0 || i >= len(c.config.Routes) {
+			log.Printf("SMTP Emailer: route %d does not exist, emailing as usual\n", i)
+			deliveries = append(deliveries, d)
+			continue
+		}
+
+		r := &c.config.Routes[i]
+		if len(r.ToEmails) > 0 {
+			s := c.config.Smtp
+			s.ToEmails = r.ToEmails
+			s.CcEmails = nil
+			d.smtp = &s
+		}
+		if r.Template != nil {
+			t := c.config.Templates[*r.Template]
+			d.template = &t
+		}
+		if r.Transport != nil {
+			t, ok := c.transports[*r.Transport]
+			if ok {
+				d.transport = t
+			} else {
+				log.Printf("SMTP Emailer: route %s transport %q does not exist\n", r.name(i), *r.Transport)
+			}
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutes(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/application", r.URL.Path)
+		require.Equal(t, "token", r.Header.Get("X-Gotify-Key"))
+		requests++
+		fmt.Fprint(w, `[{"id":3,"name":"backup"},{"id":4,"name":"web"}]`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	cfg := baseConfig
+	cfg.Hostname = strings.Replace(srv.URL, "http://", "ws://", 1)
+	cfg.Token = "token"
+	cfg.Templates = map[string]Template{
+		"failure": {Subject: toPtr("[{{.App}}] {{.Title}}"), HTML: toPtr("<p>{{.Message}}</p>")},
+	}
+	cfg.Transports = map[string]Output{"archive": {Type: outputEml, Path: dir}}
+	cfg.Routes = []Route{
+		{
+			Name:     toPtr("ops"),
+			When:     "priority >= 8 or (app == 'backup' and title contains 'FAIL')",
+			ToEmails: []string{"ops@email.com"},
+			Template: toPtr("failure"),
+		},
+		{When: "app == 'backup'", Transport: toPtr("archive"), Stop: true},
+		{When: "priority >= 0", ToEmails: []string{"all@email.com"}},
+	}
+	require.NoError(t, cfg.IsValid())
+
+	transport := &memoryTransport{}
+	p := &Plugin{config: &cfg, transport: transport, transports: newTransports(&cfg)}
+
+	require.NoError(t, p.send(Message{AppID: 3, Title: "Backup FAILED", Message: "<disk full>"}))
+	require.Len(t, transport.sent, 1)
+	content := string(transport.sent[0])
+	require.Contains(t, content, "To: ops@email.com")
+	require.Contains(t, content, "Subject: Test Subject: [backup] Backup FAILED")
+	require.Contains(t, content, "<p>&lt;disk full&gt;</p>")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	archived, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	require.Contains(t, string(archived), "To: to@email.com")
+	t.Logf("\tP\tshould email every matching route until stop")
+
+	require.NoError(t, p.send(Message{AppID: 4, Title: "Deployed", Priority: 2}))
+	require.Len(t, transport.sent, 2)
+	require.Contains(t, string(transport.sent[1]), "To: all@email.com")
+	t.Logf("\tP\tshould skip routes not matching")
+
+	cfg.Routes = []Route{{When: "priority >= 8", ToEmails: []string{"ops@email.com"}}}
+	require.NoError(t, cfg.IsValid())
+	require.NoError(t, p.send(Message{AppID: 4, Title: "Deployed", Priority: 2}))
+	require.Len(t, transport.sent, 3)
+	require.Contains(t, string(transport.sent[2]), "To: to@email.com")
+	t.Logf("\tP\tshould email as usual when no route matches")
+
+	require.Equal(t, 1, requests)
+	t.Logf("\tP\tshould cache applications")
+}
+
+func TestRouteIsValid(t *testing.T) {
+	tests := []struct {
+		name  string
+		route Route
+	}{
+		{name: "should not accept empty expression", route: Route{}},
+		{name: "should not accept invalid expression", route: Route{When: "priority >"}},
+		{name: "should not accept invalid email", route: Route{When: "priority > 3", ToEmails: []string{"ops"}}},
+		{name: "should not accept unknown template", route: Route{When: "priority > 3", Template: toPtr("missing")}},
+		{name: "should not accept unknown transport", route: Route{When: "priority > 3", Transport: toPtr("missing")}},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			cfg := baseConfig
+			cfg.Routes = []Route{tt.route}
+			require.Error(t, cfg.IsValid())
+		}
+
+		t.Run(tt.name, test)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	secretFilePrefix = "file:"
+	secretEnvPrefix  = "env:"
+)
+
+// resolveSecret is used to resolve a secret config value. Values prefixed
+// with "file:" are read from the given file, values prefixed with "env:" are
+// read from the given environment variable and any other value is used as is.
+func resolveSecret(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, secretFilePrefix):
+		path := strings.TrimSpace(strings.TrimPrefix(ref, secretFilePrefix))
+		if path == "" {
+			return "", fmt.Errorf("no file given for %q", ref)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("could not read secret file: %w", err)
+		}
+		// secret files usually end with a newline
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case strings.HasPrefix(ref, secretEnvPrefix):
+		name := strings.TrimSpace(strings.TrimPrefix(ref, secretEnvPrefix))
+		if name == "" {
+			return "", fmt.Errorf("no environment variable given for %q", ref)
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q is not set", name)
+		}
+		return value, nil
+	default:
+		return ref, nil
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "smtp_pass")
+	err := os.WriteFile(path, []byte("file-secret\n"), 0o600)
+	require.NoError(t, err)
+
+	t.Setenv("GOTIFY_SMTP_TEST_SECRET", "env-secret")
+
+	tests := []struct {
+		name string
+		ref  string
+		want string
+		pass bool
+	}{
+		{
+			name: "should return literal value",
+			ref:  "literal-secret",
+			want: "literal-secret",
+			pass: true,
+		},
+		{
+			name: "should read secret from file",
+			ref:  "file:" + path,
+			want: "file-secret",
+			pass: true,
+		},
+		{
+			name: "should read secret from environment",
+			ref:  "env:GOTIFY_SMTP_TEST_SECRET",
+			want: "env-secret",
+			pass: true,
+		},
+		{
+			name: "should not read missing file",
+			ref:  "file:" + filepath.Join(dir, "missing"),
+			pass: false,
+		},
+		{
+			name: "should not read unset environment variable",
+			ref:  "env:GOTIFY_SMTP_TEST_UNSET",
+			pass: false,
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			got, err := resolveSecret(tt.ref)
+			if tt.pass {
+				require.NoError(t, err)
+				require.Equal(t, tt.want, got)
+			} else {
+				require.Error(t, err)
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSendmailCommand = "/usr/sbin/sendmail"
+	sendmailTimeout        = time.Minute
+)
+
+var defaultSendmailArgs = []string{"-i"}
+
+// sendmail is used to pipe emails to a sendmail compatible command
+type sendmail struct {
+	command string
+	args    []string
+}
+
+// ============================================================================
+
+// newSendmail is used to create a sendmail transport
+func newSendmail(o Output) *sendmail {
+	s := sendmail{command: defaultSendmailCommand, args: defaultSendmailArgs}
+	if o.Command != nil {
+		s.command = *o.Command
+	}
+	if o.Args != nil {
+		s.args = o.Args
+	}
+
+	return &s
+}
+
+// ============================================================================
+
+// Deliver implements Transport
+// The email is written to the stdin of the command, the envelope sender is
+// passed with -f followed by the recipients.
+func (s *sendmail) Deliver(from string, to []string, content []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendmailTimeout)
+	defer cancel()
+
+	args := append([]string{}, s.args...)
+	args = append(args, "-f", from, "--")
+	args = append(args, to...)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.command, args...)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("sendmail exited with status %d: %s", exitErr.ExitCode(), msg)
+		}
+		return fmt.Errorf("could not run sendmail: %w", err)
+	}
+
+	return nil
+}
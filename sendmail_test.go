@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendmailDeliver(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "sendmail")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+echo "$@" > "$(dirname "$0")/args"
+cat > "$(dirname "$0")/message"
+if [ "$5" = "reject@email.com" ]; then
+	echo "recipient rejected" >&2
+	exit 67
+fi
+`), 0o700)
+	require.NoError(t, err)
+
+	content := []byte("Subject: test\r\n\r\ntest message\r\n")
+
+	cfg := baseConfig
+	cfg.Output = Output{Type: outputSendmail, Command: toPtr(script)}
+	require.NoError(t, cfg.IsValid())
+	transport := newTransport(&cfg)
+
+	err = transport.Deliver("from@email.com", []string{"to@email.com", "cc@email.com"}, content)
+	require.NoError(t, err)
+	t.Logf("\tP\tshould pipe email to sendmail")
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	require.Equal(t, "-i -f from@email.com -- to@email.com cc@email.com\n", string(args))
+	message, err := os.ReadFile(filepath.Join(dir, "message"))
+	require.NoError(t, err)
+	require.Equal(t, content, message)
+	t.Logf("\tP\tshould pass envelope as arguments")
+
+	err = transport.Deliver("from@email.com", []string{"reject@email.com"}, content)
+	require.EqualError(t, err, "sendmail exited with status 67: recipient rejected")
+	t.Logf("\tP\tshould report exit status and stderr")
+}
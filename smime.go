@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/smallstep/pkcs7"
+)
+
+const (
+	smimeMissingCertificatePlain = "plain"
+	smimeMissingCertificateSkip  = "skip"
+)
+
+// pkcs7Mu is used to serialize encrypting as the pkcs7 package reads the
+// content encryption algorithm from a package variable
+var pkcs7Mu sync.Mutex
+
+// SMIME represents the options for signing and encrypting emails with S/MIME
+type SMIME struct {
+	Certificate        *string           // Optional: PEM signing certificate followed by its chain, may be a file: or env: reference
+	PrivateKey         *string           // Optional: PEM key of the signing certificate, may be a file: or env: reference
+	Recipients         map[string]string // Optional: PEM certificate of each recipient email, may be a file: or env: reference
+	MissingCertificate string            // Optional: plain or skip, what recipients without a certificate get, defaults to plain
+}
+
+// ============================================================================
+
+// isValid is used to validate the S/MIME configuration
+func (s *SMIME) isValid() error {
+	v := &validation{}
+	if (s.Certificate == nil) != (s.PrivateKey == nil) {
+		v.add("", errors.New("the certificate and private key must be set together"))
+	}
+	if s.Certificate == nil && len(s.Recipients) == 0 {
+		v.add("", errors.New("a signing certificate or recipients are needed"))
+	}
+	if s.MissingCertificate != "" && s.MissingCertificate != smimeMissingCertificatePlain &&
+		s.MissingCertificate != smimeMissingCertificateSkip {
+		v.addf("missingcertificate", "the missing certificate policy %q is not valid", s.MissingCertificate)
+	}
+	for _, email := range sortedKeys(s.Recipients) {
+		path := "recipients." + email
+		v.email(path, email)
+		_, err := s.recipient(email)
+		if err != nil {
+			v.addf(path, "the certificate is not valid: %w", err)
+		}
+	}
+	if (s.Certificate == nil) == (s.PrivateKey == nil) {
+		_, _, _, err := s.signer()
+		if err != nil {
+			v.addf("certificate", "the signing certificate is not valid: %w", err)
+		}
+	}
+
+	return v.err()
+}
+
+// ============================================================================
+
+// recipient is used to parse the certificate of a recipient, nil is returned
+// when the recipient has no certificate
+func (s *SMIME) recipient(email string) (*x509.Certificate, error) {
+	var ref string
+	for k, v := range s.Recipients {
+		if strings.EqualFold(envelopeAddress(k), email) {
+			ref = v
+			break
+		}
+	}
+	if ref == "" {
+		return nil, nil
+	}
+
+	certs, err := parseCertificates(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return certs[0], nil
+}
+
+// signer is used to parse the signing certificate, its chain and key, nil is
+// returned when emails are not signed
+func (s *SMIME) signer() (*x509.Certificate, []*x509.Certificate, crypto.PrivateKey, error) {
+	if s.Certificate == nil || s.PrivateKey == nil {
+		return nil, nil, nil, nil
+	}
+
+	certs, err := parseCertificates(*s.Certificate)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ref, err := resolveSecret(*s.PrivateKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	block, _ := pem.Decode([]byte(ref))
+	if block == nil {
+		return nil, nil, nil, errors.New("no PEM private key found")
+	}
+
+	var key crypto.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return certs[0], certs[1:], key, nil
+}
+
+// parseCertificates is used to parse the PEM certificates of a config value
+func parseCertificates(ref string) ([]*x509.Certificate, error) {
+	value, err := resolveSecret(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	rest := []byte(value)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificate found")
+	}
+
+	return certs, nil
+}
+
+// ============================================================================
+
+// protect is used to sign a MIME entity and to encrypt it for the recipients
+// with a certificate. Depending on the missing certificate policy recipients
+// without a certificate get the signed entity or nothing.
+func (s *SMIME) protect(to []string, entity []byte) ([]outgoing, error) {
+	entity, err := s.sign(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyed, plain []string
+	var certs []*x509.Certificate
+	for _, email := range to {
+		cert, err := s.recipient(email)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse smime certificate of %q: %w", email, err)
+		}
+		if cert == nil {
+			plain = append(plain, email)
+			continue
+		}
+		keyed = append(keyed, email)
+		certs = append(certs, cert)
+	}
+
+	var messages []outgoing
+	if len(keyed) > 0 {
+		encrypted, err := encryptPKCS7(entity, certs)
+		if err != nil {
+			return nil, fmt.Errorf("could not encrypt smime message: %w", err)
+		}
+
+		var content bytes.Buffer
+		content.WriteString("Content-Type: application/pkcs7-mime; smime-type=enveloped-data; name=\"smime.p7m\"\r\n")
+		content.WriteString("Content-Transfer-Encoding: base64\r\n")
+		content.WriteString("Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n")
+		content.WriteString(base64Lines(encrypted) + "\r\n")
+		messages = append(messages, outgoing{to: keyed, content: content.Bytes()})
+	}
+	if len(plain) > 0 {
+		if s.MissingCertificate == smimeMissingCertificateSkip {
+			log.Printf("SMTP Emailer: not sending to recipients without an smime certificate: %s\n", strings.Join(plain, ", "))
+		} else {
+			messages = append(messages, outgoing{to: plain, content: entity})
+		}
+	}
+
+	return messages, nil
+}
+
+// sign is used to wrap a MIME entity into a multipart/signed entity (RFC 8551)
+// with a detached signature, the entity is returned as is without a signing
+// certificate
+func (s *SMIME) sign(entity []byte) ([]byte, error) {
+	cert, chain, key, err := s.signer()
+	if err != nil {
+		return nil, fmt.Errorf("could not parse smime signing certificate: %w", err)
+	}
+	if cert == nil {
+		return entity, nil
+	}
+
+	signed, err := pkcs7.NewSignedData(entity)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign smime message: %w", err)
+	}
+	signed.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	err = signed.AddSignerChain(cert, key, chain, pkcs7.SignerInfoConfig{})
+	if err != nil {
+		return nil, fmt.Errorf("could not sign smime message: %w", err)
+	}
+	signed.Detach()
+	signature, err := signed.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("could not sign smime message: %w", err)
+	}
+
+	boundary, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	var content bytes.Buffer
+	content.WriteString(fmt.Sprintf("Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; "+
+		"micalg=sha-256; boundary=\"%s\"\r\n\r\n", boundary))
+	content.WriteString("This is a cryptographically signed message in MIME format.\r\n\r\n")
+	content.WriteString("--" + boundary + "\r\n")
+	content.Write(entity)
+	content.WriteString("\r\n--" + boundary + "\r\n")
+	content.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	content.WriteString("Content-Transfer-Encoding: base64\r\n")
+	content.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	content.WriteString(base64Lines(signature) + "\r\n")
+	content.WriteString("--" + boundary + "--\r\n")
+
+	return content.Bytes(), nil
+}
+
+// encryptPKCS7 is used to encrypt content with AES-256-CBC for the
+// certificates. The pkcs7 package has no per call option for the algorithm,
+// so it is only set while encrypting and the default of other users of the
+// package is restored, the default DES is not accepted by current mail
+// clients.
+func encryptPKCS7(content []byte, certs []*x509.Certificate) ([]byte, error) {
+	pkcs7Mu.Lock()
+	defer pkcs7Mu.Unlock()
+
+	algorithm := pkcs7.ContentEncryptionAlgorithm
+	pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES256CBC
+	defer func() { pkcs7.ContentEncryptionAlgorithm = algorithm }()
+
+	return pkcs7.Encrypt(content, certs)
+}
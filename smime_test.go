@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/pkcs7"
+	"github.com/stretchr/testify/require"
+)
+
+func smimeCertificate(t *testing.T, email string) (*x509.Certificate, *rsa.PrivateKey, string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: email},
+		EmailAddresses: []string{email},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return cert, key, string(certPEM), string(keyPEM)
+}
+
+// verifySigned is used to verify a multipart/signed entity, the signed entity is returned
+func verifySigned(t *testing.T, header, body string) string {
+	mediaType, params, err := mime.ParseMediaType(header)
+	require.NoError(t, err)
+	require.Equal(t, "multipart/signed", mediaType)
+	require.Equal(t, "application/pkcs7-signature", params["protocol"])
+
+	// the signed part is verified as it was sent, before any parsing
+	boundary := "--" + params["boundary"]
+	start := strings.Index(body, boundary+"\r\n") + len(boundary) + 2
+	end := strings.Index(body[start:], "\r\n"+boundary) + start
+	signedEntity := body[start:end]
+
+	r := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	_, err = r.NextPart()
+	require.NoError(t, err)
+	part, err := r.NextPart()
+	require.NoError(t, err)
+	require.Equal(t, "application/pkcs7-signature; name=\"smime.p7s\"", part.Header.Get("Content-Type"))
+	encoded, err := io.ReadAll(part)
+	require.NoError(t, err)
+	signature, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	require.NoError(t, err)
+
+	p7, err := pkcs7.Parse(signature)
+	require.NoError(t, err)
+	p7.Content = []byte(signedEntity)
+	require.NoError(t, p7.Verify())
+
+	return signedEntity
+}
+
+func TestSMIMEProtect(t *testing.T) {
+	_, _, signerCert, signerKey := smimeCertificate(t, "from@email.com")
+	recipientCert, recipientKey, recipientPEM, _ := smimeCertificate(t, "to@email.com")
+
+	s := baseConfig.Smtp
+	s.ToEmails = []string{"to@email.com", "other@email.com"}
+	s.SMIME = &SMIME{
+		Certificate: toPtr(signerCert),
+		PrivateKey:  toPtr(signerKey),
+		Recipients:  map[string]string{"to@email.com": recipientPEM},
+	}
+	require.NoError(t, s.isValid())
+
+	messages, err := s.build(&Email{Subject: "test title", HTML: "<p>test message</p>", Text: "test message"})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	t.Logf("\tP\tshould split recipients with and without certificate")
+
+	require.Equal(t, []string{"to@email.com"}, messages[0].to)
+	msg, err := mail.ReadMessage(bytes.NewReader(messages[0].content))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(msg.Header.Get("Content-Type"), "application/pkcs7-mime; smime-type=enveloped-data"))
+	encoded, err := io.ReadAll(msg.Body)
+	require.NoError(t, err)
+	der, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	require.NoError(t, err)
+	aes256, err := asn1.Marshal(pkcs7.OIDEncryptionAlgorithmAES256CBC)
+	require.NoError(t, err)
+	require.True(t, bytes.Contains(der, aes256))
+	require.Equal(t, pkcs7.EncryptionAlgorithmDESCBC, pkcs7.ContentEncryptionAlgorithm)
+	p7, err := pkcs7.Parse(der)
+	require.NoError(t, err)
+	decrypted, err := p7.Decrypt(recipientCert, recipientKey)
+	require.NoError(t, err)
+
+	inner, err := mail.ReadMessage(bytes.NewReader(decrypted))
+	require.NoError(t, err)
+	body, err := io.ReadAll(inner.Body)
+	require.NoError(t, err)
+	signed := verifySigned(t, inner.Header.Get("Content-Type"), string(body))
+	require.Contains(t, signed, "<p>test message</p>")
+	t.Logf("\tP\tshould encrypt signed email with aes-256 for recipient with certificate")
+
+	require.Equal(t, []string{"other@email.com"}, messages[1].to)
+	msg, err = mail.ReadMessage(bytes.NewReader(messages[1].content))
+	require.NoError(t, err)
+	body, err = io.ReadAll(msg.Body)
+	require.NoError(t, err)
+	verifySigned(t, msg.Header.Get("Content-Type"), string(body))
+	t.Logf("\tP\tshould sign email for recipient without certificate")
+
+	s.SMIME.MissingCertificate = smimeMissingCertificateSkip
+	messages, err = s.build(&Email{Subject: "test title", HTML: "<p>test message</p>"})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	t.Logf("\tP\tshould skip recipient without certificate")
+}
@@ -1,26 +1,57 @@
 package main
 
 import (
-	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"math/rand"
+	"net"
+	"net/mail"
 	"net/smtp"
 	"strconv"
-	"strings"
+	"text/template"
 	"time"
 )
 
+const (
+	deliveryTo         = "to"
+	deliveryBcc        = "bcc"
+	deliveryIndividual = "individual"
+
+	tlsStartTLS = "starttls"
+	tlsImplicit = "tls"
+	tlsNone     = "none"
+)
+
 // Smtp represents an SMTP configuration
 type Smtp struct {
-	Host     string
-	Port     int
+	Host string
+	Port int
+	// Optional: starttls, tls or none, how the connection is secured,
+	// defaults to starttls
+	TLSMode string
+	// Deprecated: replaced by TLSMode none, migrated when the config is loaded
 	Insecure bool
 	Username string
-	Password *string // Optional: if empty no SMTP auth is used
+	Password *string // Optional: if empty no SMTP auth is used, may be a file: or env: reference
 	Subject  *string // Optional: included subject string
 	From     EmailFrom
 	ToEmails []string
+	CcEmails []string // Optional: emails listed as Cc
+	// Optional: to, bcc or individual, how recipients are addressed, defaults to to
+	DeliveryMode string
+	ReplyTo      []string // Optional: emails replies are sent to
+	// Optional: mailto: or https: urls recipients can unsubscribe with
+	ListUnsubscribe []string
+	// Optional: one click unsubscribing by a post to the https url (RFC 8058)
+	ListUnsubscribePost bool
+	// Optional: custom headers by name, values are templates of the Gotify
+	// message, for example {{.Title}}
+	Headers map[string]string
+	DKIM    *DKIM  // Optional: sign emails with DKIM
+	PGP     *PGP   // Optional: encrypt emails with OpenPGP
+	SMIME   *SMIME // Optional: sign and encrypt emails with S/MIME
+
+	headerTemplates map[string]*template.Template // parsed custom headers, set by isValidHeaders
 }
 
 type EmailFrom struct {
@@ -28,83 +59,365 @@ type EmailFrom struct {
 	Name  *string // Optional: name included in email from
 }
 
+// recipientError represents a recipient the SMTP server did not accept
+type recipientError struct {
+	Rcpt string
+	Err  error
+}
+
+// Error implements error
+func (e *recipientError) Error() string {
+	return fmt.Sprintf("recipient %s was not accepted: %v", e.Rcpt, e.Err)
+}
+
+// Unwrap is used to get the reply of the SMTP server
+func (e *recipientError) Unwrap() error {
+	return e.Err
+}
+
 // isValid is used to validate the Smtp configuration
 func (s *Smtp) isValid() error {
+	return errors.Join(s.isValidRelay(), s.isValidSender())
+}
+
+// isValidSender is used to validate the sender and recipients of the Smtp
+// configuration, these are needed by every output
+func (s *Smtp) isValidSender() error {
+	v := &validation{}
+	if s.Username == "" {
+		v.add("username", errors.New("the username is required"))
+	}
+	if s.From.Email != nil {
+		v.email("from.email", *s.From.Email)
+	} else if s.Username != "" {
+		// the username is the sender when no from email is set
+		_, err := mail.ParseAddress(s.Username)
+		if err != nil {
+			v.addf("username", "the username %q is not an email, set from.email", s.Username)
+		}
+	}
+	v.emails("toemails", s.ToEmails, true)
+	v.emails("ccemails", s.CcEmails, false)
+	switch s.DeliveryMode {
+	case "", deliveryTo, deliveryBcc, deliveryIndividual:
+	default:
+		v.addf("deliverymode", "the delivery mode %q is not valid", s.DeliveryMode)
+	}
+	v.add("", s.isValidHeaders())
+	if s.DKIM != nil {
+		v.add("dkim", s.DKIM.isValid())
+	}
+	if s.PGP != nil {
+		v.add("pgp", s.PGP.isValid())
+	}
+	if s.SMIME != nil {
+		if s.PGP != nil {
+			v.add("smime", errors.New("only one of pgp and smime can be used"))
+		}
+		v.add("smime", s.SMIME.isValid())
+	}
+
+	return v.err()
+}
+
+// isValidRelay is used to validate the connection settings of the Smtp
+// configuration, these are all that is needed for fallback relays
+func (s *Smtp) isValidRelay() error {
+	v := &validation{}
 	if s.Host == "" {
-		return errors.New("the smtp host is not valid")
+		v.add("host", errors.New("the host is required"))
 	}
-	if s.Port == 0 {
-		return errors.New("the smtp port is not valid")
+	v.port("port", s.Port)
+	if s.Insecure {
+		v.add("insecure", errors.New("insecure is replaced by tlsmode: none"))
 	}
-	if s.Username == "" {
-		return errors.New("the smtp from email is not valid")
+	switch s.TLSMode {
+	case "", tlsStartTLS, tlsImplicit, tlsNone:
+	default:
+		v.addf("tlsmode", "the tls mode %q is not valid", s.TLSMode)
 	}
-	if len(s.ToEmails) < 1 {
-		return errors.New("the smtp to emails are not valid")
+	if s.Password != nil {
+		_, err := resolveSecret(*s.Password)
+		if err != nil {
+			v.addf("password", "the password could not be resolved: %w", err)
+		}
 	}
 
-	return nil
+	return v.err()
 }
 
 // ============================================================================
 
-// Send is used to send an SMTP email
-func (s *Smtp) Send(title, message string) error {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	messageID := strconv.FormatInt(r.Int63(), 10) + "@" + s.Host
+// outgoing represents a built email and its envelope
+type outgoing struct {
+	from    string
+	to      []string
+	content []byte
+}
+
+// recipients represents the recipients of one email
+type recipients struct {
+	to       []string // To header, undisclosed recipients when empty
+	cc       []string // Cc header
+	envelope []string // bare addresses the email is delivered to
+}
+
+// recipients is used to group the recipients into emails according to the
+// delivery mode
+func (s *Smtp) recipients() []recipients {
+	all := append(append([]string{}, s.ToEmails...), s.CcEmails...)
+	envelope := make([]string, 0, len(all))
+	for _, email := range all {
+		envelope = append(envelope, envelopeAddress(email))
+	}
+
+	switch s.DeliveryMode {
+	case deliveryBcc:
+		return []recipients{{cc: s.CcEmails, envelope: envelope}}
+	case deliveryIndividual:
+		groups := make([]recipients, 0, len(all))
+		for i, email := range all {
+			groups = append(groups, recipients{to: []string{email}, envelope: envelope[i : i+1]})
+		}
+		return groups
+	default:
+		return []recipients{{to: s.ToEmails, cc: s.CcEmails, envelope: envelope}}
+	}
+}
 
-	subject := title
+// build is used to build the raw emails for the recipients. Recipients can
+// get different emails, for example when only some of them can be encrypted
+// for.
+func (s *Smtp) build(email *Email) ([]outgoing, error) {
+	subject := email.Subject
 	if s.Subject != nil {
-		subject = fmt.Sprintf("%s: %s", *s.Subject, title)
+		subject = fmt.Sprintf("%s: %s", *s.Subject, email.Subject)
 	}
 
-	text := "<div>"
-	text += "<h3>"
-	text += title
-	text += "</h3>"
-	text += "<p>"
-	text += message
-	text += "</p>"
-	text += "</div>"
+	body, err := composeBody(email)
+	if err != nil {
+		return nil, fmt.Errorf("could not write email body: %w", err)
+	}
+	entity := body.bytes()
+
+	var messages []outgoing
+	for _, rcpt := range s.recipients() {
+		group := []outgoing{{to: rcpt.envelope, content: entity}}
+		switch {
+		case s.PGP != nil:
+			group, err = s.PGP.encrypt(rcpt.envelope, entity)
+		case s.SMIME != nil:
+			group, err = s.SMIME.protect(rcpt.envelope, entity)
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	var content bytes.Buffer
-	fromEmail := s.Username
+		for _, m := range group {
+			header, err := s.header(rcpt, email, subject)
+			if err != nil {
+				return nil, err
+			}
+
+			m.from = s.fromEmail()
+			m.content = append(composeHeader(header), m.content...)
+			if s.DKIM != nil {
+				m.content, err = s.DKIM.sign(m.content)
+				if err != nil {
+					return nil, err
+				}
+			}
+			messages = append(messages, m)
+		}
+	}
+
+	return messages, nil
+}
+
+// fromEmail is used to get the email address emails are sent from
+func (s *Smtp) fromEmail() string {
 	if s.From.Email != nil {
-		fromEmail = *s.From.Email
+		return *s.From.Email
 	}
 
-	if s.From.Name != nil {
-		content.WriteString(fmt.Sprintf("From: %s <%s>\n", *s.From.Name, fromEmail))
-	} else {
-		content.WriteString(fmt.Sprintf("From: %s\n", fromEmail))
+	return s.Username
+}
+
+// header is used to build the header fields of an email to the recipients
+func (s *Smtp) header(rcpt recipients, email *Email, subject string) ([]headerField, error) {
+	to := "undisclosed-recipients:;"
+	if len(rcpt.to) > 0 {
+		var err error
+		to, err = formatAddressList(rcpt.to)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	content.WriteString(fmt.Sprintf(
-		"To: %s\n", strings.Join(s.ToEmails, ", ")))
-	content.WriteString(fmt.Sprintf("Subject: %s\n", subject))
-	content.WriteString("MIME-version: 1.0;\n")
-	content.WriteString("Content-Type: text/html; charset=\"UTF-8\";\n")
-	content.WriteString(fmt.Sprintf("Message-ID: <%s>\n\n", messageID))
-	content.WriteString(text)
+	messageID := email.MessageID
+	if messageID == "" {
+		var err error
+		messageID, err = newMessageID(s.fromEmail())
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	var auth smtp.Auth
-	authType := "nil"
-	if s.Password != nil {
-		if s.Insecure {
-			auth = smtp.CRAMMD5Auth(s.Username, *s.Password)
-			authType = "CRAMMD5Auth"
-		} else {
-			auth = smtp.PlainAuth("", s.Username, *s.Password, s.Host)
-			authType = "PlainAuth"
+	header := []headerField{
+		{"From", formatAddress(s.From.Name, s.fromEmail())},
+		{"To", to},
+	}
+	if len(rcpt.cc) > 0 {
+		cc, err := formatAddressList(rcpt.cc)
+		if err != nil {
+			return nil, err
 		}
+		header = append(header, headerField{"Cc", cc})
+	}
+	header = append(header,
+		headerField{"Subject", encodeHeader(subject)},
+		headerField{"Date", formatDate(time.Now())},
+		headerField{"Message-ID", messageID},
+		headerField{"MIME-Version", "1.0"},
+	)
+	if email.InReplyTo != "" {
+		header = append(header,
+			headerField{"In-Reply-To", email.InReplyTo},
+			headerField{"References", email.InReplyTo},
+		)
+	}
+
+	list, err := s.listHeaders()
+	if err != nil {
+		return nil, err
+	}
+	header = append(header, list...)
+	header = append(header, email.Headers...)
+
+	return header, nil
+}
+
+// Deliver implements Transport
+// It is used to deliver a raw email through the SMTP server
+func (s *Smtp) Deliver(from string, to []string, content []byte) error {
+	client, err := s.session()
+	if err != nil {
+		return fmt.Errorf("could not send email: %w", err)
 	}
+	defer client.Close()
 
-	fmt.Printf("SMTP Emailer: sending with auth='%s'\n", authType)
-	uri := fmt.Sprintf("%s:%d", s.Host, s.Port)
-	err := smtp.SendMail(uri, auth, fromEmail, s.ToEmails, content.Bytes())
+	err = client.Mail(from)
+	if err != nil {
+		return fmt.Errorf("could not send email: %w", err)
+	}
+	for _, rcpt := range to {
+		err = client.Rcpt(rcpt)
+		if err != nil {
+			return fmt.Errorf("could not send email: %w", &recipientError{Rcpt: rcpt, Err: err})
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("could not send email: %w", err)
+	}
+	_, err = w.Write(content)
+	if err != nil {
+		return fmt.Errorf("could not send email: %w", err)
+	}
+	err = w.Close()
 	if err != nil {
 		return fmt.Errorf("could not send email: %w", err)
 	}
 
-	return nil
+	return client.Quit()
+}
+
+// verify is used to check that the SMTP server accepts a session, it runs
+// EHLO, STARTTLS and AUTH without sending an email
+func (s *Smtp) verify() error {
+	client, err := s.session()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.Quit()
+}
+
+// session is used to open an SMTP session secured by the tls mode and
+// authenticated when a password is set
+func (s *Smtp) session() (*smtp.Client, error) {
+	auth, authType, err := s.auth()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("SMTP Emailer: connecting with auth='%s'\n", authType)
+	uri := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	if s.TLSMode == tlsImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", uri, &tls.Config{ServerName: s.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", uri)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not dial: %w", err)
+	}
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not start session: %w", err)
+	}
+
+	err = client.Hello("localhost")
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("could not say hello: %w", err)
+	}
+	switch s.TLSMode {
+	case tlsImplicit, tlsNone:
+	default:
+		// never fall back to plain text, a missing STARTTLS may be stripped
+		// by someone in between
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("the server does not support starttls")
+		}
+		err = client.StartTLS(&tls.Config{ServerName: s.Host})
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("could not start tls: %w", err)
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			client.Close()
+			return nil, errors.New("the server does not support auth")
+		}
+		err = client.Auth(auth)
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("could not authenticate: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+// auth is used to get the SMTP auth of the tls mode, nil when no password is
+// set
+func (s *Smtp) auth() (smtp.Auth, string, error) {
+	if s.Password == nil {
+		return nil, "nil", nil
+	}
+	password, err := resolveSecret(*s.Password)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not resolve password: %w", err)
+	}
+	if s.TLSMode == tlsNone {
+		return smtp.CRAMMD5Auth(s.Username, password), "CRAMMD5Auth", nil
+	}
+
+	return smtp.PlainAuth("", s.Username, password, s.Host), "PlainAuth", nil
 }
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/mail"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -30,7 +32,7 @@ func TestSmtpSend(t *testing.T) {
 			smtp: Smtp{
 				Host:     "localhost",
 				Port:     s.MailhogPort,
-				Insecure: true,
+				TLSMode:  tlsNone,
 				Username: "from@email.com",
 				Password: toPtr("password"),
 				Subject:  toPtr("Test Subject"),
@@ -45,7 +47,7 @@ func TestSmtpSend(t *testing.T) {
 			smtp: Smtp{
 				Host:     "localhost",
 				Port:     s.MailhogPort,
-				Insecure: true,
+				TLSMode:  tlsNone,
 				Username: "username@email.com",
 				Password: toPtr("password"),
 				Subject:  toPtr("Test Subject"),
@@ -65,7 +67,7 @@ func TestSmtpSend(t *testing.T) {
 			err := tt.smtp.isValid()
 			require.NoError(t, err)
 
-			err = tt.smtp.Send("test title", "test message")
+			err = (&Plugin{}).deliver(&tt.smtp, &tt.smtp, &Email{Subject: "test title", HTML: "test message"})
 			require.NoError(t, err)
 
 			// Get client token
@@ -94,6 +96,69 @@ func TestSmtpSend(t *testing.T) {
 	}
 }
 
+func TestSmtpRecipients(t *testing.T) {
+	type want struct {
+		to       string
+		cc       string
+		envelope []string
+	}
+
+	tests := []struct {
+		name string
+		mode string
+		want []want
+	}{
+		{
+			name: "should share to header",
+			mode: deliveryTo,
+			want: []want{
+				{to: "a@email.com, \"Bee\" <b@email.com>", cc: "c@email.com", envelope: []string{"a@email.com", "b@email.com", "c@email.com"}},
+			},
+		},
+		{
+			name: "should hide recipients with bcc",
+			mode: deliveryBcc,
+			want: []want{
+				{to: "undisclosed-recipients:;", cc: "c@email.com", envelope: []string{"a@email.com", "b@email.com", "c@email.com"}},
+			},
+		},
+		{
+			name: "should send one email per recipient",
+			mode: deliveryIndividual,
+			want: []want{
+				{to: "a@email.com", envelope: []string{"a@email.com"}},
+				{to: "\"Bee\" <b@email.com>", envelope: []string{"b@email.com"}},
+				{to: "c@email.com", envelope: []string{"c@email.com"}},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("when testing #%d: %s", i, tt.name)
+			s := baseConfig.Smtp
+			s.ToEmails = []string{"a@email.com", "Bee <b@email.com>"}
+			s.CcEmails = []string{"c@email.com"}
+			s.DeliveryMode = tt.mode
+			require.NoError(t, s.isValid())
+
+			messages, err := s.build(&Email{Subject: "test title", HTML: "test message"})
+			require.NoError(t, err)
+			require.Len(t, messages, len(tt.want))
+
+			for j, w := range tt.want {
+				msg, err := mail.ReadMessage(bytes.NewReader(messages[j].content))
+				require.NoError(t, err)
+				require.Equal(t, w.to, msg.Header.Get("To"))
+				require.Equal(t, w.cc, msg.Header.Get("Cc"))
+				require.Equal(t, w.envelope, messages[j].to)
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
 type MailhogRes struct {
 	Count int
 	Items []MailhogItem
@@ -110,3 +175,33 @@ type MailhogMail struct {
 	Domain  string
 	Mailbox string
 }
+
+func TestSmtpSessionTLSMode(t *testing.T) {
+	server := newFakeSMTP(t, "250 ok")
+
+	tests := []struct {
+		name    string
+		tlsMode string
+		err     string
+	}{
+		{name: "should not fall back to plain text without starttls", tlsMode: tlsStartTLS, err: "the server does not support starttls"},
+		{name: "should default to starttls", err: "the server does not support starttls"},
+		{name: "should not upgrade with tls mode none", tlsMode: tlsNone},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			s := Smtp{Host: "127.0.0.1", Port: server.port(), TLSMode: tt.tlsMode}
+			client, err := s.session()
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, client.Quit())
+		}
+
+		t.Run(tt.name, test)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gotify/plugin-api"
+)
+
+// storageData represents the plugin state persisted by Gotify
+type storageData struct {
+	Client      *clientState           `json:",omitempty"` // Client managed by the plugin
+	Threads     map[string]threadState `json:",omitempty"` // Thread roots by thread key
+	Held        []heldMessage          `json:",omitempty"` // Messages held during quiet hours
+	Escalations []escalationState      `json:",omitempty"` // Escalations of unacknowledged messages
+	Migration   *migrationState        `json:",omitempty"` // Last config migration the admin was notified of
+}
+
+// ============================================================================
+
+// SetStorageHandler implements plugin.Storager
+// Invoked during initialization
+func (c *Plugin) SetStorageHandler(h plugin.StorageHandler) {
+	c.storageHandler = h
+}
+
+// ============================================================================
+
+// loadStorage is used to load the persisted plugin state
+func (c *Plugin) loadStorage() (storageData, error) {
+	var data storageData
+	if c.storageHandler == nil {
+		return data, nil
+	}
+
+	b, err := c.storageHandler.Load()
+	if err != nil {
+		return data, fmt.Errorf("could not load storage: %w", err)
+	}
+	if len(b) == 0 {
+		return data, nil
+	}
+
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		return data, fmt.Errorf("could not unmarshal storage: %w", err)
+	}
+
+	return data, nil
+}
+
+// updateStorage is used to modify and persist the plugin state
+func (c *Plugin) updateStorage(update func(data *storageData)) error {
+	c.storageMu.Lock()
+	defer c.storageMu.Unlock()
+
+	data, err := c.loadStorage()
+	if err != nil {
+		return err
+	}
+
+	update(&data)
+
+	if c.storageHandler == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("could not marshal storage: %w", err)
+	}
+	err = c.storageHandler.Save(b)
+	if err != nil {
+		return fmt.Errorf("could not save storage: %w", err)
+	}
+
+	return nil
+}
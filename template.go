@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"text/template"
+	"time"
+)
+
+// Template represents a named email template, the fields are templates of the
+// Gotify message
+type Template struct {
+	Subject *string // Optional: template of the subject, for example [{{.App}}] {{.Title}}
+	HTML    *string // Optional: html template of the body, values are escaped
+	Text    *string // Optional: template of the plain text alternative
+
+	subject *template.Template     // parsed subject, set by isValid
+	html    *htmltemplate.Template // parsed html, set by isValid
+	text    *template.Template     // parsed text, set by isValid
+}
+
+// templateData represents the values available to templates
+type templateData struct {
+	ID       uint
+	AppID    uint
+	App      string // Name of the application
+	Title    string
+	Message  string
+	Priority int
+	Extras   map[string]interface{}
+	Date     time.Time
+}
+
+// ============================================================================
+
+// isValid is used to validate the template configuration, the templates are
+// parsed once here so emails are rendered without parsing them again
+func (t *Template) isValid() error {
+	v := &validation{}
+	if t.Subject == nil && t.HTML == nil && t.Text == nil {
+		v.add("", errors.New("the template needs a subject, html or text"))
+	}
+	t.subject, t.html, t.text = nil, nil, nil
+	if t.Subject != nil {
+		subject, err := template.New("subject").Parse(*t.Subject)
+		if err != nil {
+			v.addf("subject", "the subject is not valid: %w", err)
+		}
+		t.subject = subject
+	}
+	if t.HTML != nil {
+		html, err := htmltemplate.New("html").Parse(*t.HTML)
+		if err != nil {
+			v.addf("html", "the html is not valid: %w", err)
+		}
+		t.html = html
+	}
+	if t.Text != nil {
+		text, err := template.New("text").Parse(*t.Text)
+		if err != nil {
+			v.addf("text", "the text is not valid: %w", err)
+		}
+		t.text = text
+	}
+
+	return v.err()
+}
+
+// ============================================================================
+
+// apply is used to replace the parts of an email the template sets, inline
+// images are dropped when the html is replaced
+func (t *Template) apply(email *Email, data templateData) error {
+	if t.subject != nil {
+		var subject bytes.Buffer
+		err := t.subject.Execute(&subject, data)
+		if err != nil {
+			return fmt.Errorf("could not render template subject: %w", err)
+		}
+		email.Subject = subject.String()
+	}
+
+	if t.html != nil {
+		var html bytes.Buffer
+		err := t.html.Execute(&html, data)
+		if err != nil {
+			return fmt.Errorf("could not render template html: %w", err)
+		}
+		email.HTML = html.String()
+		email.Inline = nil
+	}
+
+	if t.text != nil {
+		var text bytes.Buffer
+		err := t.text.Execute(&text, data)
+		if err != nil {
+			return fmt.Errorf("could not render template text: %w", err)
+		}
+		email.Text = text.String()
+	}
+
+	return nil
+}
+
+// templateData is used to get the template values of a message
+func (c *Plugin) templateData(msg Message) templateData {
+	return templateData{
+		ID:       msg.ID,
+		AppID:    msg.AppID,
+		App:      c.appName(msg.AppID),
+		Title:    msg.Title,
+		Message:  msg.Message,
+		Priority: msg.Priority,
+		Extras:   msg.Extras,
+		Date:     msg.Date,
+	}
+}
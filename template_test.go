@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateApply(t *testing.T) {
+	data := templateData{App: "backup", Title: "Backup failed", Message: "<b>disk</b> full", Priority: 9}
+
+	tests := []struct {
+		name     string
+		template Template
+		want     Email
+	}{
+		{
+			name:     "should replace subject",
+			template: Template{Subject: toPtr("[{{.App}}] {{.Title}} ({{.Priority}})")},
+			want:     Email{Subject: "[backup] Backup failed (9)", HTML: "<p>html</p>", Text: "text", Inline: []Attachment{{}}},
+		},
+		{
+			name:     "should escape html and drop inline images",
+			template: Template{HTML: toPtr("<p>{{.Message}}</p>")},
+			want:     Email{Subject: "subject", HTML: "<p>&lt;b&gt;disk&lt;/b&gt; full</p>", Text: "text"},
+		},
+		{
+			name:     "should replace text",
+			template: Template{Text: toPtr("{{.Title}}: {{.Message}}")},
+			want:     Email{Subject: "subject", HTML: "<p>html</p>", Text: "Backup failed: <b>disk</b> full", Inline: []Attachment{{}}},
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			require.NoError(t, tt.template.isValid())
+			email := Email{Subject: "subject", HTML: "<p>html</p>", Text: "text", Inline: []Attachment{{}}}
+			require.NoError(t, tt.template.apply(&email, data))
+			require.Equal(t, tt.want, email)
+		}
+
+		t.Run(tt.name, test)
+	}
+
+	require.Error(t, (&Template{}).isValid())
+	require.Error(t, (&Template{Subject: toPtr("{{.Title")}).isValid())
+	missing := Template{Text: toPtr("{{.Missing}}")}
+	require.NoError(t, missing.isValid())
+	require.Error(t, missing.apply(&Email{}, data))
+}
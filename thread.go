@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	threadByApp      = "app"
+	threadByAppTitle = "app-title"
+
+	// maxThreads is the number of thread roots kept in storage, the least
+	// recently used roots are forgotten first
+	maxThreads = 1000
+)
+
+// Threading represents the options for threading emails in mail clients
+type Threading struct {
+	Key string // Optional: app or app-title, what emails are threaded by, defaults to app
+}
+
+// threadState represents a persisted thread root
+type threadState struct {
+	MessageID string    // Message-ID of the first email of the thread
+	Updated   time.Time // Last time an email was added to the thread
+}
+
+// ============================================================================
+
+// isValid is used to validate the Threading configuration
+func (t *Threading) isValid() error {
+	v := &validation{}
+	switch t.Key {
+	case "", threadByApp, threadByAppTitle:
+	default:
+		v.addf("key", "the key %q is not valid", t.Key)
+	}
+
+	return v.err()
+}
+
+// key is used to get the thread key of a Gotify message
+func (t *Threading) key(msg Message) string {
+	if t.Key == threadByAppTitle {
+		return fmt.Sprintf("%d/%s", msg.AppID, msg.Title)
+	}
+
+	return fmt.Sprintf("%d", msg.AppID)
+}
+
+// ============================================================================
+
+// thread is used to add an email to the thread of its Gotify message. The
+// first email of a thread gets a new Message-ID, later emails reply to the
+// root.
+func (c *Plugin) thread(msg Message, email *Email) error {
+	data, err := c.loadStorage()
+	if err != nil {
+		return err
+	}
+
+	root, ok := data.Threads[c.config.Threading.key(msg)]
+	if ok {
+		email.InReplyTo = root.MessageID
+		return nil
+	}
+
+	messageID, err := newMessageID(c.config.Smtp.fromEmail())
+	if err != nil {
+		return err
+	}
+	email.MessageID = messageID
+
+	return nil
+}
+
+// saveThread is used to persist the thread of a delivered email, a root is
+// only kept once it was delivered so later emails do not reply to an email
+// nobody received
+func (c *Plugin) saveThread(msg Message, email *Email) error {
+	key := c.config.Threading.key(msg)
+	return c.updateStorage(func(data *storageData) {
+		if data.Threads == nil {
+			data.Threads = map[string]threadState{}
+		}
+
+		root, ok := data.Threads[key]
+		if !ok {
+			if email.MessageID == "" {
+				return
+			}
+			root = threadState{MessageID: email.MessageID}
+		}
+		root.Updated = time.Now()
+		data.Threads[key] = root
+
+		for len(data.Threads) > maxThreads {
+			oldest := key
+			for k, t := range data.Threads {
+				if t.Updated.Before(data.Threads[oldest].Updated) {
+					oldest = k
+				}
+			}
+			delete(data.Threads, oldest)
+		}
+	})
+}
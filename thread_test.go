@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThreadingKey(t *testing.T) {
+	msg := Message{AppID: 3, Title: "disk full"}
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{name: "should thread by app by default", key: "", want: "3"},
+		{name: "should thread by app", key: threadByApp, want: "3"},
+		{name: "should thread by app and title", key: threadByAppTitle, want: "3/disk full"},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			threading := Threading{Key: tt.key}
+			require.NoError(t, threading.isValid())
+			require.Equal(t, tt.want, threading.key(msg))
+		}
+
+		t.Run(tt.name, test)
+	}
+
+	require.Error(t, (&Threading{Key: "title"}).isValid())
+}
+
+func TestThread(t *testing.T) {
+	cfg := baseConfig
+	cfg.Threading = &Threading{}
+	storage := &memoryStorage{}
+	p := &Plugin{config: &cfg}
+	p.SetStorageHandler(storage)
+
+	first := &Email{Subject: "test title", HTML: "<p>test message</p>"}
+	require.NoError(t, p.thread(Message{AppID: 1}, first))
+	require.NotEmpty(t, first.MessageID)
+	require.Empty(t, first.InReplyTo)
+	require.NoError(t, p.saveThread(Message{AppID: 1}, first))
+	t.Logf("\tP\tshould start thread with first email")
+
+	// a new plugin instance uses the persisted thread roots
+	p = &Plugin{config: &cfg}
+	p.SetStorageHandler(storage)
+	reply := &Email{Subject: "test title", HTML: "<p>test message</p>"}
+	require.NoError(t, p.thread(Message{AppID: 1}, reply))
+	require.Empty(t, reply.MessageID)
+	require.Equal(t, first.MessageID, reply.InReplyTo)
+	t.Logf("\tP\tshould reply to thread root after restart")
+
+	messages, err := cfg.Smtp.build(reply)
+	require.NoError(t, err)
+	msg, err := mail.ReadMessage(bytes.NewReader(messages[0].content))
+	require.NoError(t, err)
+	require.Equal(t, first.MessageID, msg.Header.Get("In-Reply-To"))
+	require.Equal(t, first.MessageID, msg.Header.Get("References"))
+	require.NotEqual(t, first.MessageID, msg.Header.Get("Message-ID"))
+	messages, err = cfg.Smtp.build(first)
+	require.NoError(t, err)
+	msg, err = mail.ReadMessage(bytes.NewReader(messages[0].content))
+	require.NoError(t, err)
+	require.Equal(t, first.MessageID, msg.Header.Get("Message-ID"))
+	t.Logf("\tP\tshould add thread headers")
+
+	other := &Email{Subject: "test title", HTML: "<p>test message</p>"}
+	require.NoError(t, p.thread(Message{AppID: 2}, other))
+	require.NotEqual(t, first.MessageID, other.MessageID)
+	require.Empty(t, other.InReplyTo)
+	t.Logf("\tP\tshould start thread for other app")
+
+	data := storageData{Threads: map[string]threadState{}}
+	for i := 0; i < maxThreads; i++ {
+		data.Threads[fmt.Sprintf("%d", i+10)] = threadState{MessageID: "<old@email.com>", Updated: time.Now()}
+	}
+	data.Threads["1"] = threadState{MessageID: first.MessageID, Updated: time.Now().Add(-time.Hour)}
+	b, err := json.Marshal(data)
+	require.NoError(t, err)
+	storage.data = b
+	require.NoError(t, p.thread(Message{AppID: 2}, other))
+	require.NoError(t, p.saveThread(Message{AppID: 2}, other))
+	data, err = p.loadStorage()
+	require.NoError(t, err)
+	require.Len(t, data.Threads, maxThreads)
+	require.NotContains(t, data.Threads, "1")
+	t.Logf("\tP\tshould forget least recently used thread roots")
+}
+
+func TestThreadUndelivered(t *testing.T) {
+	cfg := baseConfig
+	cfg.Threading = &Threading{}
+	transport := &memoryTransport{err: errors.New("relay unavailable")}
+	p := &Plugin{config: &cfg, transport: transport}
+	p.SetStorageHandler(&memoryStorage{})
+
+	require.Error(t, p.send(Message{AppID: 1, Title: "first title"}))
+	data, err := p.loadStorage()
+	require.NoError(t, err)
+	require.Empty(t, data.Threads)
+	t.Logf("\tP\tshould not keep the root of an undelivered email")
+
+	transport.err = nil
+	require.NoError(t, p.send(Message{AppID: 1, Title: "second title"}))
+	require.NotContains(t, string(transport.sent[0]), "In-Reply-To")
+	data, err = p.loadStorage()
+	require.NoError(t, err)
+	require.Contains(t, data.Threads, "1")
+	t.Logf("\tP\tshould start the thread with the first delivered email")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+)
+
+const (
+	outputSmtp     = "smtp"
+	outputMaildir  = "maildir"
+	outputMbox     = "mbox"
+	outputEml      = "eml"
+	outputSendmail = "sendmail"
+)
+
+// Transport is used to deliver a rendered email
+type Transport interface {
+	Deliver(from string, to []string, content []byte) error
+}
+
+// Output represents where emails are delivered to
+type Output struct {
+	Type     string   // Optional: smtp, maildir, mbox, eml or sendmail, defaults to smtp
+	Path     string   // Optional: maildir or eml directory or mbox file, needed for local files
+	MaxBytes *int64   // Optional: size the output may grow to before it is rotated
+	MaxFiles *int     // Optional: messages kept in a directory or rotated mbox files kept
+	Command  *string  // Optional: sendmail compatible command, defaults to /usr/sbin/sendmail
+	Args     []string // Optional: sendmail arguments passed before the envelope, defaults to -i
+}
+
+// ============================================================================
+
+// isSmtp is used to check if emails are delivered through smtp
+func (o *Output) isSmtp() bool {
+	return o.Type == "" || o.Type == outputSmtp
+}
+
+// isValid is used to validate the output configuration
+func (o *Output) isValid() error {
+	v := &validation{}
+	switch o.Type {
+	case "", outputSmtp:
+		return nil
+	case outputSendmail:
+		if o.Command != nil && *o.Command == "" {
+			v.add("command", errors.New("the command is empty"))
+		}
+		return v.err()
+	case outputMaildir, outputMbox, outputEml:
+	default:
+		v.addf("type", "the type %q is not valid", o.Type)
+		return v.err()
+	}
+
+	if o.Path == "" {
+		v.add("path", errors.New("the path is required"))
+	}
+	if o.MaxBytes != nil && *o.MaxBytes <= 0 {
+		v.addf("maxbytes", "the max bytes %d is not positive", *o.MaxBytes)
+	}
+	if o.MaxFiles != nil && *o.MaxFiles <= 0 {
+		v.addf("maxfiles", "the max files %d is not positive", *o.MaxFiles)
+	}
+
+	return v.err()
+}
+
+// ============================================================================
+
+// newTransport is used to create the transport for the configured output
+func newTransport(c *Config) Transport {
+	return newOutputTransport(c, c.Output)
+}
+
+// newTransports is used to create the named transports used by routes
+func newTransports(c *Config) map[string]Transport {
+	transports := make(map[string]Transport, len(c.Transports))
+	for name, o := range c.Transports {
+		transports[name] = newOutputTransport(c, o)
+	}
+
+	return transports
+}
+
+// newOutputTransport is used to create the transport for an output
+func newOutputTransport(c *Config, o Output) Transport {
+	switch o.Type {
+	case outputMaildir:
+		return newMaildir(o)
+	case outputMbox:
+		return newMbox(o)
+	case outputEml:
+		return newEmlDir(o)
+	case outputSendmail:
+		return newSendmail(o)
+	default:
+		return newRelayPool(c)
+	}
+}
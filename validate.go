@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// fieldError represents a problem of the config value at a YAML path
+type fieldError struct {
+	Path string // YAML path of the value, for example smtp.toemails[0]
+	Err  error
+}
+
+// validation is used to collect every problem of a config instead of stopping
+// at the first one
+type validation struct {
+	errs []error
+}
+
+// ============================================================================
+
+// Error implements error
+func (e *fieldError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap is used to get the problem without its path
+func (e *fieldError) Unwrap() error {
+	return e.Err
+}
+
+// ============================================================================
+
+// add is used to record a problem at a path relative to the validated value,
+// the problems of a nested validation are recorded one by one below the path
+func (v *validation) add(path string, err error) {
+	if err == nil {
+		return
+	}
+
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		for _, err := range e.Unwrap() {
+			v.add(path, err)
+		}
+	case *fieldError:
+		v.errs = append(v.errs, &fieldError{Path: joinPath(path, e.Path), Err: e.Err})
+	default:
+		v.errs = append(v.errs, &fieldError{Path: path, Err: err})
+	}
+}
+
+// addf is used to record a formatted problem at a path
+func (v *validation) addf(path string, format string, a ...any) {
+	v.add(path, fmt.Errorf(format, a...))
+}
+
+// email is used to check that a value is a single email address
+func (v *validation) email(path string, email string) {
+	_, err := mail.ParseAddress(email)
+	if err != nil {
+		v.addf(path, "the email %q is not valid", email)
+	}
+}
+
+// emails is used to check a list of email addresses, at least one is needed
+// when required
+func (v *validation) emails(path string, emails []string, required bool) {
+	if required && len(emails) == 0 {
+		v.addf(path, "at least one email is needed")
+	}
+	for i, email := range emails {
+		v.email(fmt.Sprintf("%s[%d]", path, i), email)
+	}
+}
+
+// port is used to check that a value is a tcp port
+func (v *validation) port(path string, port int) {
+	if port < 1 || port > 65535 {
+		v.addf(path, "the port %d is not between 1 and 65535", port)
+	}
+}
+
+// url is used to check that a value is an absolute url of one of the schemes
+func (v *validation) url(path string, uri string, schemes ...string) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		v.addf(path, "the url %q is not valid: %w", uri, err)
+		return
+	}
+	if !u.IsAbs() || (u.Host == "" && u.Opaque == "") {
+		v.addf(path, "the url %q is not absolute", uri)
+		return
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return
+		}
+	}
+	if len(schemes) > 0 {
+		v.addf(path, "the url %q does not use %s", uri, strings.Join(schemes, ", "))
+	}
+}
+
+// err is used to get every recorded problem, nil when the config is valid
+func (v *validation) err() error {
+	return errors.Join(v.errs...)
+}
+
+// ============================================================================
+
+// sortedKeys is used to get the keys of a config map in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// joinPath is used to join a YAML path and a path below it
+func joinPath(path, sub string) string {
+	switch {
+	case path == "":
+		return sub
+	case sub == "":
+		return path
+	case strings.HasPrefix(sub, "["):
+		return path + sub
+	default:
+		return path + "." + sub
+	}
+}
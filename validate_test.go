@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationAdd(t *testing.T) {
+	nested := &validation{}
+	nested.add("host", errors.New("the host is required"))
+	nested.add("", &fieldError{Path: "[1]", Err: errors.New("the email is not valid")})
+
+	v := &validation{}
+	v.add("relays[0]", nested.err())
+	v.add("token", nil)
+	v.add("", errors.New("top level"))
+
+	var paths []string
+	for _, err := range v.errs {
+		var field *fieldError
+		require.True(t, errors.As(err, &field))
+		paths = append(paths, field.Path)
+	}
+	require.Equal(t, []string{"relays[0].host", "relays[0][1]", ""}, paths)
+	require.Equal(t, "relays[0].host: the host is required\nrelays[0][1]: the email is not valid\ntop level", v.err().Error())
+	require.NoError(t, (&validation{}).err())
+}
+
+func TestConfigIsValidErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func() Config
+		errors []string
+	}{
+		{
+			name:   "should accept valid config",
+			config: func() Config { return baseConfig },
+		},
+		{
+			name: "should list every problem with its path",
+			config: func() Config {
+				cfg := baseConfig
+				cfg.Hostname = "ftp://localhost"
+				cfg.Token = ""
+				cfg.Smtp.Port = 70000
+				cfg.Smtp.ToEmails = []string{"to@email.com", "foo"}
+				cfg.Smtp.CcEmails = []string{"bar"}
+				cfg.Relays = []Smtp{{Port: 25}}
+				cfg.BaseURL = toPtr("gotify.example.com")
+				return cfg
+			},
+			errors: []string{
+				`hostname: the url "ftp://localhost" does not use ws, wss`,
+				"token: the token or client is required",
+				"smtp.port: the port 70000 is not between 1 and 65535",
+				`smtp.toemails[1]: the email "foo" is not valid`,
+				`smtp.ccemails[0]: the email "bar" is not valid`,
+				"relays[0].host: the host is required",
+				`baseurl: the url "gotify.example.com" is not absolute`,
+			},
+		},
+		{
+			name: "should not accept a username sender that is not an email",
+			config: func() Config {
+				cfg := baseConfig
+				cfg.Smtp.Username = "apikey"
+				cfg.Smtp.From = EmailFrom{}
+				return cfg
+			},
+			errors: []string{`smtp.username: the username "apikey" is not an email, set from.email`},
+		},
+		{
+			name: "should name nested paths",
+			config: func() Config {
+				cfg := baseConfig
+				cfg.QuietHours = &QuietHours{Periods: []QuietPeriod{{Start: "25:00", End: "07:00"}}}
+				cfg.Routes = []Route{{When: "priority >", ToEmails: []string{"ops"}}}
+				cfg.Templates = map[string]Template{"alert": {}}
+				return cfg
+			},
+			errors: []string{
+				"quiethours.periods[0].start",
+				"templates.alert: the template needs a subject, html or text",
+				"routes[0].when",
+				`routes[0].toemails[0]: the email "ops" is not valid`,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			cfg := tt.config()
+			cfg.Smtp.ToEmails = append([]string{}, cfg.Smtp.ToEmails...)
+			err := cfg.IsValid()
+			if len(tt.errors) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			lines := strings.Split(err.Error(), "\n")
+			require.Len(t, lines, len(tt.errors))
+			for j, want := range tt.errors {
+				require.True(t, strings.HasPrefix(lines[j], want), "got %q, want %q", lines[j], want)
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
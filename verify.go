@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// verifyTimeout is how long the Gotify websocket handshake may take when a
+// config is verified
+const verifyTimeout = 10 * time.Second
+
+// ============================================================================
+
+// verify is used to check that the smtp servers and Gotify accept a config
+// without sending an email, every failure is returned with the YAML path at
+// fault
+func (c *Plugin) verify(config *Config) error {
+	v := &validation{}
+
+	if config.usesSmtp() {
+		v.add("smtp", config.Smtp.verify())
+		for i := range config.Relays {
+			v.add(fmt.Sprintf("relays[%d]", i), config.Relays[i].verify())
+		}
+	}
+
+	path := "token"
+	if config.Client != nil {
+		path = "client"
+	}
+	token, err := c.verifyToken(config)
+	if err != nil {
+		v.add(path, err)
+	} else if token != "" {
+		err = config.verifyWS(token)
+		if err == errUnauthorized {
+			v.add(path, fmt.Errorf("gotify rejected the token: %w", err))
+		} else {
+			v.add("hostname", err)
+		}
+	}
+
+	return v.err()
+}
+
+// verifyToken is used to get the token verified with Gotify, a managed client
+// without a stored token is created when the plugin is enabled so it is not
+// verified
+func (c *Plugin) verifyToken(config *Config) (string, error) {
+	if config.Client == nil {
+		return resolveSecret(config.Token)
+	}
+
+	data, err := c.loadStorage()
+	if err != nil {
+		return "", err
+	}
+	if data.Client == nil || data.Client.Token == "" {
+		log.Println("SMTP Emailer: the managed client does not exist yet, skipping its verification")
+		return "", nil
+	}
+
+	return data.Client.Token, nil
+}
+
+// ============================================================================
+
+// usesSmtp is used to check if any email is delivered through the smtp server
+func (c *Config) usesSmtp() bool {
+	if c.Output.isSmtp() {
+		return true
+	}
+	for _, o := range c.Transports {
+		if o.isSmtp() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifyWS is used to open and close the Gotify websocket once
+func (c *Config) verifyWS(token string) error {
+	dialer := websocket.Dialer{HandshakeTimeout: verifyTimeout}
+	ws, res, err := dialer.Dial(fmt.Sprintf("%s/stream?token=%s", c.Hostname, token), nil)
+	if res != nil && res.StatusCode == http.StatusUnauthorized {
+		return errUnauthorized
+	}
+	if err != nil {
+		if res != nil {
+			return fmt.Errorf("could not open the websocket: %s: %w", res.Status, err)
+		}
+		return fmt.Errorf("could not open the websocket: %w", err)
+	}
+
+	return ws.Close()
+}
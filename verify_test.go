@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeGotify is used to start a websocket stream accepting one token
+func newFakeGotify(t *testing.T, token string) string {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stream" || r.URL.Query().Get("token") != token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ws.Close()
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL
+}
+
+func TestVerify(t *testing.T) {
+	smtpServer := newFakeSMTP(t, "250 ok")
+	smtpServer.password = "password"
+	hostname := newFakeGotify(t, "token")
+
+	tests := []struct {
+		name   string
+		config func() Config
+		errors []string
+	}{
+		{
+			name:   "should accept working smtp server and token",
+			config: func() Config { return Config{} },
+		},
+		{
+			name: "should not accept wrong smtp password",
+			config: func() Config {
+				return Config{Smtp: Smtp{Password: toPtr("wrong")}}
+			},
+			errors: []string{"smtp: could not authenticate: 535"},
+		},
+		{
+			name: "should not accept unreachable relay",
+			config: func() Config {
+				return Config{Relays: []Smtp{{Host: "127.0.0.1", Port: closedPort(t), TLSMode: tlsNone}}}
+			},
+			errors: []string{"relays[0]: could not dial"},
+		},
+		{
+			name:   "should not accept rejected token",
+			config: func() Config { return Config{Token: "wrong"} },
+			errors: []string{"token: gotify rejected the token"},
+		},
+		{
+			name: "should not verify smtp server of other outputs",
+			config: func() Config {
+				return Config{Smtp: Smtp{Password: toPtr("wrong")}, Output: Output{Type: outputEml, Path: t.TempDir()}}
+			},
+		},
+		{
+			name: "should list every failure",
+			config: func() Config {
+				return Config{Smtp: Smtp{Password: toPtr("wrong")}, Token: "wrong"}
+			},
+			errors: []string{"smtp: could not authenticate", "token: gotify rejected the token"},
+		},
+	}
+
+	for i, tt := range tests {
+		test := func(t *testing.T) {
+			t.Logf("When testing #%d: %s", i, tt.name)
+			cfg := baseConfig
+			cfg.Hostname = hostname
+			cfg.Smtp.Host = "127.0.0.1"
+			cfg.Smtp.Port = smtpServer.port()
+			cfg.Smtp.TLSMode = tlsNone
+			override := tt.config()
+			if override.Smtp.Password != nil {
+				cfg.Smtp.Password = override.Smtp.Password
+			}
+			if override.Token != "" {
+				cfg.Token = override.Token
+			}
+			cfg.Relays = override.Relays
+			cfg.Output = override.Output
+			require.NoError(t, cfg.IsValid())
+
+			err := (&Plugin{}).verify(&cfg)
+			if len(tt.errors) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			lines := strings.Split(err.Error(), "\n")
+			require.Len(t, lines, len(tt.errors))
+			for j, want := range tt.errors {
+				require.True(t, strings.HasPrefix(lines[j], want), "got %q, want %q", lines[j], want)
+			}
+		}
+
+		t.Run(tt.name, test)
+	}
+}
+
+func TestValidateAndSetConfigVerifies(t *testing.T) {
+	smtpServer := newFakeSMTP(t, "250 ok")
+	smtpServer.password = "password"
+
+	cfg := baseConfig
+	cfg.Version = currentConfigVersion
+	cfg.Hostname = newFakeGotify(t, "token")
+	cfg.Smtp.Host = "127.0.0.1"
+	cfg.Smtp.Port = smtpServer.port()
+	cfg.Smtp.TLSMode = tlsNone
+	cfg.Smtp.Password = toPtr("wrong")
+	cfg.VerifyOnSave = true
+
+	p := &Plugin{}
+	loaded := cfg
+	require.NoError(t, p.ValidateAndSetConfig(&loaded))
+	t.Logf("\tP\tshould not verify the stored config when it is loaded")
+
+	saved := cfg
+	err := p.ValidateAndSetConfig(&saved)
+	require.ErrorContains(t, err, "smtp: could not authenticate: 535")
+	require.Same(t, &loaded, p.config)
+	t.Logf("\tP\tshould reject a saved config that does not verify")
+
+	saved = cfg
+	saved.Smtp.Password = toPtr("password")
+	require.NoError(t, p.ValidateAndSetConfig(&saved))
+	require.Same(t, &saved, p.config)
+	t.Logf("\tP\tshould accept a saved config that verifies")
+
+	p = &Plugin{}
+	require.Error(t, p.ValidateAndSetConfig(p.DefaultConfig()))
+	saved = cfg
+	err = p.ValidateAndSetConfig(&saved)
+	require.ErrorContains(t, err, "smtp: could not authenticate: 535")
+	require.Nil(t, p.config)
+	t.Logf("\tP\tshould verify the first save after an invalid stored config")
+}